@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+const (
+	apiTypeOpenAi  = "openai"
+	apiTypeAzure   = "azure"
+	apiTypeAzureAd = "azure_ad"
+)
+
+// Enabled reports whether the OpenAI API is configured. The token is not required
+// for the OpenAI-compatible APIs, which are given by the base URL.
+func (o *OpenAi) Enabled() bool {
+	return o.Token != "" || o.BaseUrl != ""
+}
+
+// ClientConfig builds the OpenAI client configuration from the command line options.
+func (o *OpenAi) ClientConfig() (openai.ClientConfig, error) {
+	var cfg openai.ClientConfig
+
+	switch o.ApiType {
+	case apiTypeAzure, apiTypeAzureAd:
+		cfg = openai.DefaultAzureConfig(o.Token, o.BaseUrl)
+		if o.ApiType == apiTypeAzureAd {
+			cfg.APIType = openai.APITypeAzureAD
+		}
+		if o.ApiVersion != "" {
+			cfg.APIVersion = o.ApiVersion
+		}
+
+		deployments, err := parseAzureDeployments(o.AzureDeployments)
+		if err != nil {
+			return cfg, fmt.Errorf("OPENAI_AZURE_DEPLOYMENTS: %w", err)
+		}
+		defaultMapper := cfg.AzureModelMapperFunc
+		cfg.AzureModelMapperFunc = func(model string) string {
+			if deployment, ok := deployments[model]; ok {
+				return deployment
+			}
+			return defaultMapper(model)
+		}
+	default:
+		cfg = openai.DefaultConfig(o.Token)
+		if o.BaseUrl != "" {
+			cfg.BaseURL = strings.TrimRight(o.BaseUrl, "/")
+		}
+	}
+
+	cfg.OrgID = o.Org
+
+	transport := http.DefaultTransport.(*http.Transport).Clone() // nolint:forcetypeassert // always *http.Transport
+	if o.Proxy != "" {
+		proxyUrl, err := url.Parse(o.Proxy)
+		if err != nil {
+			return cfg, fmt.Errorf("OPENAI_PROXY: %w", err)
+		}
+		transport.Proxy = http.ProxyURL(proxyUrl)
+	}
+
+	headers, err := parseHeaders(o.Headers)
+	if err != nil {
+		return cfg, fmt.Errorf("OPENAI_HEADERS: %w", err)
+	}
+
+	cfg.HTTPClient = &http.Client{
+		Transport: &headerTransport{
+			headers: headers,
+			next:    transport,
+		},
+	}
+
+	return cfg, nil
+}
+
+// headerTransport adds extra headers to every request.
+type headerTransport struct {
+	headers http.Header
+	next    http.RoundTripper
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if len(t.headers) > 0 {
+		req = req.Clone(req.Context())
+		for name, values := range t.headers {
+			req.Header[name] = values
+		}
+	}
+	return t.next.RoundTrip(req)
+}
+
+// parseHeaders parses a list of `Name: value` headers.
+func parseHeaders(values []string) (http.Header, error) {
+	headers := http.Header{}
+	for _, v := range values {
+		name, value, ok := strings.Cut(v, ":")
+		if !ok || strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("invalid header %q: expected Name: value", v)
+		}
+		headers.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+	return headers, nil
+}
+
+// parseAzureDeployments parses a comma-separated list of `model=deployment` entries.
+func parseAzureDeployments(s string) (map[string]string, error) {
+	deployments := make(map[string]string)
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		model, deployment, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid deployment entry %q: expected model=deployment", entry)
+		}
+		deployments[strings.TrimSpace(model)] = strings.TrimSpace(deployment)
+	}
+	return deployments, nil
+}
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"path"
 	"runtime/debug"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/mkuznets/telebot/v3"
@@ -22,18 +24,65 @@ import (
 const (
 	longPollTimeout       = 10 * time.Second
 	maxWebhookConnections = 16
+
+	// The defaults of the Chat settings, which must match their tags.
+	defaultChatModel   = "gpt-3.5-turbo"
+	defaultReplyTokens = 1024
 )
 
 type RunCommand struct {
-	OpenAi   *OpenAi   `group:"OpenAI parameters" namespace:"openai" env-namespace:"OPENAI"`
-	Telegram *Telegram `group:"Telegram parameters" namespace:"telegram" env-namespace:"TELEGRAM"`
-	Data     *Data     `group:"Data parameters" namespace:"data" env-namespace:"DATA"`
+	Chat      *Chat      `group:"Chat parameters" namespace:"chat" env-namespace:"CHAT"`
+	OpenAi    *OpenAi    `group:"OpenAI parameters" namespace:"openai" env-namespace:"OPENAI"`
+	Ollama    *Ollama    `group:"Ollama parameters" namespace:"ollama" env-namespace:"OLLAMA"`
+	Anthropic *Anthropic `group:"Anthropic parameters" namespace:"anthropic" env-namespace:"ANTHROPIC"`
+	Telegram  *Telegram  `group:"Telegram parameters" namespace:"telegram" env-namespace:"TELEGRAM"`
+	Data      *Data      `group:"Data parameters" namespace:"data" env-namespace:"DATA"`
+	Usage     *Usage     `group:"Usage parameters" namespace:"usage" env-namespace:"USAGE"`
+	Quota     *Quota     `group:"Quota parameters (0 means no limit)" namespace:"quota" env-namespace:"QUOTA"`
+}
+
+// Chat holds the chat model settings that apply to all backends.
+type Chat struct {
+	Model          string `long:"model" env:"MODEL" description:"Default chat model" default:"gpt-3.5-turbo"`
+	ModelUsers     string `long:"model-users" env:"MODEL_USERS" description:"Restrict chat models to specific users (model=chat_id;chat_id,...)"`
+	Fallbacks      string `long:"fallbacks" env:"FALLBACKS" description:"Models to try when a model is unavailable (model=fallback;fallback,...)"`
+	ContextWindows string `long:"context-windows" env:"CONTEXT_WINDOWS" description:"Context sizes of the chat models in tokens, in addition to the built-in ones (model=tokens,...)"`
+	SummaryModel   string `long:"summary-model" env:"SUMMARY_MODEL" description:"Chat model used to summarize long dialogs (summarization is disabled if empty)"`
+	ReplyTokens    int    `long:"reply-tokens" env:"REPLY_TOKENS" description:"Number of context tokens reserved for the reply" default:"1024"`
 }
 
 type OpenAi struct {
-	Token      string `long:"token" env:"TOKEN" description:"OpenAI API token" required:"true"`
-	ChatModel  string `long:"chat-model" env:"CHAT_MODEL" description:"OpenAI chat model" default:"gpt-3.5-turbo-0301"`
-	AudioModel string `long:"audio-model" env:"AUDIO_MODEL" description:"OpenAI audio transctiption model" default:"whisper-1"`
+	Token      string   `long:"token" env:"TOKEN" description:"OpenAI API token"`
+	AudioModel string   `long:"audio-model" env:"AUDIO_MODEL" description:"OpenAI audio transctiption model" default:"whisper-1"`
+	Models     []string `long:"models" env:"MODELS" env-delim:"," description:"Additional chat models served by OpenAI that users can choose with /model"`
+
+	// The former names of the Chat settings, which are still honoured.
+	ChatModel      string `long:"chat-model" env:"CHAT_MODEL" description:"Deprecated, use CHAT_MODEL" hidden:"true"`
+	ModelUsers     string `long:"model-users" env:"MODEL_USERS" description:"Deprecated, use CHAT_MODEL_USERS" hidden:"true"`
+	Fallbacks      string `long:"fallbacks" env:"FALLBACKS" description:"Deprecated, use CHAT_FALLBACKS" hidden:"true"`
+	ContextWindows string `long:"context-windows" env:"CONTEXT_WINDOWS" description:"Deprecated, use CHAT_CONTEXT_WINDOWS" hidden:"true"`
+	SummaryModel   string `long:"summary-model" env:"SUMMARY_MODEL" description:"Deprecated, use CHAT_SUMMARY_MODEL" hidden:"true"`
+	ReplyTokens    int    `long:"reply-tokens" env:"REPLY_TOKENS" description:"Deprecated, use CHAT_REPLY_TOKENS" hidden:"true"`
+
+	BaseUrl          string   `long:"base-url" env:"BASE_URL" description:"Base URL of the OpenAI-compatible API"`
+	Org              string   `long:"org" env:"ORG" description:"OpenAI organization ID"`
+	Headers          []string `long:"header" env:"HEADERS" env-delim:"," description:"Extra HTTP header sent to the API (Name: value)"`
+	Proxy            string   `long:"proxy" env:"PROXY" description:"HTTP proxy URL used to connect to the API"`
+	ApiType          string   `long:"api-type" env:"API_TYPE" description:"API flavour" default:"openai" choice:"openai" choice:"azure" choice:"azure_ad"`
+	ApiVersion       string   `long:"api-version" env:"API_VERSION" description:"Azure OpenAI API version"`
+	AzureDeployments string   `long:"azure-deployments" env:"AZURE_DEPLOYMENTS" description:"Azure OpenAI deployment names (model=deployment,...)"`
+}
+
+type Ollama struct {
+	Url    string   `long:"url" env:"URL" description:"Ollama server URL (e.g. http://localhost:11434)"`
+	Models []string `long:"models" env:"MODELS" env-delim:"," description:"Chat models served by Ollama"`
+}
+
+type Anthropic struct {
+	Token     string   `long:"token" env:"TOKEN" description:"Anthropic API key"`
+	BaseUrl   string   `long:"base-url" env:"BASE_URL" description:"Base URL of the Anthropic API" default:"https://api.anthropic.com"`
+	Models    []string `long:"models" env:"MODELS" env-delim:"," description:"Chat models served by Anthropic"`
+	MaxTokens int      `long:"max-tokens" env:"MAX_TOKENS" description:"Maximum number of tokens in a response" default:"4096"`
 }
 
 type Telegram struct {
@@ -49,8 +98,31 @@ type Webhook struct {
 }
 
 type Data struct {
-	Dir                string `long:"dir" env:"DIR" description:"Database directory" required:"true"`
-	EncryptionPassword string `long:"encryption-password" env:"ENCRYPTION_PASSWORD" description:"Encryption password for messages"`
+	Dir                string        `long:"dir" env:"DIR" description:"Database directory" required:"true"`
+	EncryptionPassword string        `long:"encryption-password" env:"ENCRYPTION_PASSWORD" description:"Encryption password for messages"`
+	DialogRetention    time.Duration `long:"dialog-retention" env:"DIALOG_RETENTION" description:"Time of inactivity after which the dialog expires (0 means never)" default:"1h"`
+	HistoryRetention   time.Duration `long:"history-retention" env:"HISTORY_RETENTION" description:"Time after the last message when past dialogs are deleted (0 means never)" default:"2160h"`
+	SweepInterval      time.Duration `long:"sweep-interval" env:"SWEEP_INTERVAL" description:"Interval between deletions of the data past its retention" default:"1h"`
+}
+
+type Usage struct {
+	Retention time.Duration `long:"retention" env:"RETENTION" description:"Age of the usage records to be deleted (0 means never)" default:"0"`
+	Prices    string        `long:"prices" env:"PRICES" description:"Prices of 1K prompt/completion tokens in USD (model=prompt/completion,...), or of a minute of audio (model=price/min)" default:"gpt-3.5-turbo-16k=0.003/0.004,gpt-3.5-turbo=0.0015/0.002,gpt-4-32k=0.06/0.12,gpt-4=0.03/0.06,whisper-1=0.006/min"`
+}
+
+type Quota struct {
+	UserDailyTokens     int     `long:"user-daily-tokens" env:"USER_DAILY_TOKENS" description:"Maximum number of tokens per user per day" default:"0"`
+	UserMonthlyTokens   int     `long:"user-monthly-tokens" env:"USER_MONTHLY_TOKENS" description:"Maximum number of tokens per user per month" default:"0"`
+	UserDailyCost       float64 `long:"user-daily-cost" env:"USER_DAILY_COST" description:"Maximum estimated cost in USD per user per day" default:"0"`
+	UserMonthlyCost     float64 `long:"user-monthly-cost" env:"USER_MONTHLY_COST" description:"Maximum estimated cost in USD per user per month" default:"0"`
+	GroupDailyTokens    int     `long:"group-daily-tokens" env:"GROUP_DAILY_TOKENS" description:"Maximum number of tokens per group chat per day" default:"0"`
+	GroupMonthlyTokens  int     `long:"group-monthly-tokens" env:"GROUP_MONTHLY_TOKENS" description:"Maximum number of tokens per group chat per month" default:"0"`
+	GroupDailyCost      float64 `long:"group-daily-cost" env:"GROUP_DAILY_COST" description:"Maximum estimated cost in USD per group chat per day" default:"0"`
+	GroupMonthlyCost    float64 `long:"group-monthly-cost" env:"GROUP_MONTHLY_COST" description:"Maximum estimated cost in USD per group chat per month" default:"0"`
+	GlobalDailyTokens   int     `long:"global-daily-tokens" env:"GLOBAL_DAILY_TOKENS" description:"Maximum number of tokens for all users per day" default:"0"`
+	GlobalMonthlyTokens int     `long:"global-monthly-tokens" env:"GLOBAL_MONTHLY_TOKENS" description:"Maximum number of tokens for all users per month" default:"0"`
+	GlobalDailyCost     float64 `long:"global-daily-cost" env:"GLOBAL_DAILY_COST" description:"Maximum estimated cost in USD for all users per day" default:"0"`
+	GlobalMonthlyCost   float64 `long:"global-monthly-cost" env:"GLOBAL_MONTHLY_COST" description:"Maximum estimated cost in USD for all users per month" default:"0"`
 }
 
 func (r *RunCommand) Validate() error {
@@ -58,12 +130,113 @@ func (r *RunCommand) Validate() error {
 		return fmt.Errorf("EnsureDir: %w", err)
 	}
 
+	if err := r.applyDeprecated(); err != nil {
+		return err
+	}
+
 	if r.Telegram.Mode == "webhook" {
 		if r.Telegram.Webhook.Url == "" {
 			return fmt.Errorf("TELEGRAM_WEBHOOK_URL is required")
 		}
 	}
 
+	if r.Data.DialogRetention < 0 {
+		return fmt.Errorf("DATA_DIALOG_RETENTION must not be negative")
+	}
+	if r.Data.HistoryRetention < 0 {
+		return fmt.Errorf("DATA_HISTORY_RETENTION must not be negative")
+	}
+	if r.Data.SweepInterval <= 0 {
+		return fmt.Errorf("DATA_SWEEP_INTERVAL must be positive")
+	}
+	if r.Usage.Retention < 0 {
+		return fmt.Errorf("USAGE_RETENTION must not be negative")
+	}
+
+	if _, err := jeepity.ParsePriceTable(r.Usage.Prices); err != nil {
+		return fmt.Errorf("USAGE_PRICES: %w", err)
+	}
+
+	if _, err := jeepity.ParseModelAccess(r.Chat.ModelUsers); err != nil {
+		return fmt.Errorf("CHAT_MODEL_USERS: %w", err)
+	}
+
+	// The token is only required for the models served by OpenAI.
+	// OpenAI-compatible APIs given by the base URL may not need one.
+	if !r.OpenAi.Enabled() {
+		if models := r.openAiModels(); len(models) > 0 {
+			return fmt.Errorf("OPENAI_TOKEN is required for %s", strings.Join(models, ", "))
+		}
+	}
+
+	if r.Ollama.Url == "" && len(r.Ollama.Models) > 0 {
+		return fmt.Errorf("OLLAMA_URL is required for OLLAMA_MODELS")
+	}
+
+	if r.Anthropic.Token == "" && len(r.Anthropic.Models) > 0 {
+		return fmt.Errorf("ANTHROPIC_TOKEN is required for ANTHROPIC_MODELS")
+	}
+
+	if _, err := jeepity.ParseModelFallbacks(r.Chat.Fallbacks); err != nil {
+		return fmt.Errorf("CHAT_FALLBACKS: %w", err)
+	}
+
+	windows, err := jeepity.ParseContextWindows(r.Chat.ContextWindows)
+	if err != nil {
+		return fmt.Errorf("CHAT_CONTEXT_WINDOWS: %w", err)
+	}
+	// Only the models in use matter: the built-in windows may list smaller models that are not configured.
+	for _, model := range r.chatModels() {
+		if size := windows.Lookup(model); size <= r.Chat.ReplyTokens {
+			return fmt.Errorf("CHAT_REPLY_TOKENS must be less than the context window of %s (%d)", model, size)
+		}
+	}
+
+	if r.OpenAi.ApiType != apiTypeOpenAi && r.OpenAi.BaseUrl == "" {
+		return fmt.Errorf("OPENAI_BASE_URL is required for OPENAI_API_TYPE=%s", r.OpenAi.ApiType)
+	}
+
+	if _, err := r.OpenAi.ClientConfig(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// applyDeprecated moves the settings given by their former OPENAI_ names to the Chat group.
+// Since the defaults cannot be told apart from the explicit values, the former name takes
+// precedence over a default, and the conflicting values of both names are rejected.
+func (r *RunCommand) applyDeprecated() error {
+	settings := []struct {
+		current, former *string
+		def             string
+		name            string
+	}{
+		{&r.Chat.Model, &r.OpenAi.ChatModel, defaultChatModel, "MODEL"},
+		{&r.Chat.ModelUsers, &r.OpenAi.ModelUsers, "", "MODEL_USERS"},
+		{&r.Chat.Fallbacks, &r.OpenAi.Fallbacks, "", "FALLBACKS"},
+		{&r.Chat.ContextWindows, &r.OpenAi.ContextWindows, "", "CONTEXT_WINDOWS"},
+		{&r.Chat.SummaryModel, &r.OpenAi.SummaryModel, "", "SUMMARY_MODEL"},
+	}
+	for _, s := range settings {
+		if *s.former == "" {
+			continue
+		}
+		if *s.current != s.def && *s.current != *s.former {
+			return fmt.Errorf("OPENAI_%s is deprecated and conflicts with CHAT_%s", s.name, s.name)
+		}
+		slog.Warn(fmt.Sprintf("OPENAI_%s is deprecated, use CHAT_%s", s.name, s.name))
+		*s.current = *s.former
+	}
+
+	if r.OpenAi.ReplyTokens != 0 {
+		if r.Chat.ReplyTokens != defaultReplyTokens && r.Chat.ReplyTokens != r.OpenAi.ReplyTokens {
+			return fmt.Errorf("OPENAI_REPLY_TOKENS is deprecated and conflicts with CHAT_REPLY_TOKENS")
+		}
+		slog.Warn("OPENAI_REPLY_TOKENS is deprecated, use CHAT_REPLY_TOKENS")
+		r.Chat.ReplyTokens = r.OpenAi.ReplyTokens
+	}
+
 	return nil
 }
 
@@ -110,10 +283,89 @@ func (r *RunCommand) Execute([]string) error {
 
 	inviteCode := ybot.InviteCode()
 	st.SetDefaultInviteCode(inviteCode)
+	st.SetDefaultDialogRetention(r.Data.DialogRetention)
+
+	prices, err := jeepity.ParsePriceTable(r.Usage.Prices)
+	if err != nil {
+		return fmt.Errorf("ParsePriceTable: %w", err)
+	}
+
+	modelAccess, err := jeepity.ParseModelAccess(r.Chat.ModelUsers)
+	if err != nil {
+		return fmt.Errorf("ParseModelAccess: %w", err)
+	}
+
+	fallbacks, err := jeepity.ParseModelFallbacks(r.Chat.Fallbacks)
+	if err != nil {
+		return fmt.Errorf("ParseModelFallbacks: %w", err)
+	}
+
+	contextWindows, err := jeepity.ParseContextWindows(r.Chat.ContextWindows)
+	if err != nil {
+		return fmt.Errorf("ParseContextWindows: %w", err)
+	}
+
+	aiConfig, err := r.OpenAi.ClientConfig()
+	if err != nil {
+		return fmt.Errorf("ClientConfig: %w", err)
+	}
+
+	// Without OpenAI, the chat models are served by the other backends only, and voice messages are not transcribed.
+	var (
+		chat        *jeepity.ChatRouter
+		transcriber jeepity.Transcriber
+	)
+	if r.OpenAi.Enabled() {
+		ai := jeepity.NewOpenAiProvider(openai.NewClientWithConfig(aiConfig))
+		chat = jeepity.NewChatRouter(ai)
+		transcriber = ai
+	} else {
+		chat = jeepity.NewChatRouter(nil)
+	}
+
+	models := r.OpenAi.Models
+	if r.Ollama.Url != "" {
+		chat.Register(jeepity.NewOllamaProvider(r.Ollama.Url), r.Ollama.Models...)
+		models = append(models, r.Ollama.Models...)
+	}
+	if r.Anthropic.Token != "" {
+		chat.Register(jeepity.NewAnthropicProvider(r.Anthropic.BaseUrl, r.Anthropic.Token, r.Anthropic.MaxTokens), r.Anthropic.Models...)
+		models = append(models, r.Anthropic.Models...)
+	}
 
-	ai := openai.NewClient(r.OpenAi.Token)
 	e := jeepity.NewAesEncryptor(r.Data.EncryptionPassword)
-	bh := jeepity.NewBotHandler(critCtx, ai, st, e)
+	bh := jeepity.NewBotHandler(critCtx, chat, transcriber, st, e, &jeepity.Config{
+		ChatModel:       r.Chat.Model,
+		Models:          models,
+		ModelAccess:     modelAccess,
+		Fallbacks:       fallbacks,
+		ContextWindows:  contextWindows,
+		ReplyTokens:     r.Chat.ReplyTokens,
+		SummaryModel:    r.Chat.SummaryModel,
+		DialogRetention: r.Data.DialogRetention,
+		AudioModel:      r.OpenAi.AudioModel,
+		Prices:          prices,
+		Quotas: jeepity.Quotas{
+			User: jeepity.Quota{
+				DailyTokens:   r.Quota.UserDailyTokens,
+				MonthlyTokens: r.Quota.UserMonthlyTokens,
+				DailyCost:     r.Quota.UserDailyCost,
+				MonthlyCost:   r.Quota.UserMonthlyCost,
+			},
+			Group: jeepity.Quota{
+				DailyTokens:   r.Quota.GroupDailyTokens,
+				MonthlyTokens: r.Quota.GroupMonthlyTokens,
+				DailyCost:     r.Quota.GroupDailyCost,
+				MonthlyCost:   r.Quota.GroupMonthlyCost,
+			},
+			Global: jeepity.Quota{
+				DailyTokens:   r.Quota.GlobalDailyTokens,
+				MonthlyTokens: r.Quota.GlobalMonthlyTokens,
+				DailyCost:     r.Quota.GlobalDailyCost,
+				MonthlyCost:   r.Quota.GlobalMonthlyCost,
+			},
+		},
+	})
 	bh.Configure(bot)
 
 	g, _ := errgroup.WithContext(critCtx)
@@ -127,6 +379,13 @@ func (r *RunCommand) Execute([]string) error {
 		return nil
 	})
 
+	g.Go(func() error {
+		return st.RunSweeper(ctx, r.Data.SweepInterval, store.SweepPolicy{
+			HistoryRetention: r.Data.HistoryRetention,
+			UsageRetention:   r.Usage.Retention,
+		})
+	})
+
 	g.Go(func() error {
 		<-ctx.Done()
 
@@ -148,3 +407,48 @@ func (r *RunCommand) Execute([]string) error {
 
 	return nil
 }
+
+// chatModels returns all configured chat models: the default, summary, and fallback ones,
+// and the models of all backends.
+func (r *RunCommand) chatModels() []string {
+	configured := []string{r.Chat.Model, r.Chat.SummaryModel}
+	configured = append(configured, r.OpenAi.Models...)
+	configured = append(configured, r.Ollama.Models...)
+	configured = append(configured, r.Anthropic.Models...)
+	fallbacks, _ := jeepity.ParseModelFallbacks(r.Chat.Fallbacks)
+	for model, chain := range fallbacks {
+		configured = append(configured, model)
+		configured = append(configured, chain...)
+	}
+
+	var models []string
+	seen := make(map[string]bool)
+	for _, m := range configured {
+		if m == "" || seen[m] {
+			continue
+		}
+		seen[m] = true
+		models = append(models, m)
+	}
+	sort.Strings(models)
+	return models
+}
+
+// openAiModels returns the configured chat models that are not served by the other backends.
+func (r *RunCommand) openAiModels() []string {
+	other := make(map[string]bool)
+	for _, m := range r.Ollama.Models {
+		other[m] = true
+	}
+	for _, m := range r.Anthropic.Models {
+		other[m] = true
+	}
+
+	var models []string
+	for _, m := range r.chatModels() {
+		if !other[m] {
+			models = append(models, m)
+		}
+	}
+	return models
+}
@@ -9,9 +9,11 @@ require (
 	github.com/jmoiron/sqlx v1.3.5
 	github.com/joho/godotenv v1.5.1
 	github.com/mattn/go-sqlite3 v1.14.17
-	github.com/mkuznets/telebot/v3 v3.1.8
+	github.com/mkuznets/telebot/v3 v3.1.9
 	github.com/nicksnyder/go-i18n/v2 v2.2.1
 	github.com/oklog/ulid/v2 v2.1.0
+	github.com/pkoukk/tiktoken-go v0.1.8
+	github.com/pkoukk/tiktoken-go-loader v0.0.2
 	github.com/sashabaranov/go-openai v1.14.1
 	golang.org/x/exp v0.0.0-20230809150735-7b3493d9a819
 	golang.org/x/sync v0.10.0
@@ -22,7 +24,9 @@ require (
 )
 
 require (
+	github.com/dlclark/regexp2 v1.10.0 // indirect
 	github.com/dlsniper/debugger v0.6.0 // indirect
+	github.com/google/uuid v1.3.0 // indirect
 	github.com/jessevdk/go-flags v1.5.0 // indirect
 	github.com/mattn/go-isatty v0.0.17 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
@@ -4,8 +4,10 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"io"
+	"html"
+	"math"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -16,9 +18,7 @@ import (
 	"github.com/h2non/filetype"
 	"github.com/mkuznets/telebot/v3"
 	"github.com/mkuznets/telebot/v3/middleware"
-	"github.com/sashabaranov/go-openai"
 	"golang.org/x/exp/slog"
-	"golang.org/x/sync/errgroup"
 	"mkuznets.com/go/ytils/ylog"
 	"ytils.dev/heartbeat"
 
@@ -28,8 +28,7 @@ import (
 )
 
 const (
-	gptUser         = "jeepity"
-	defaultGptModel = openai.GPT3Dot5Turbo
+	gptUser = "jeepity"
 
 	backoffDuration = 500 * time.Millisecond
 	backoffRepeats  = 5
@@ -44,6 +43,8 @@ var (
 	ErrNotApproved    = errors.New("not approved")
 	ErrContextTooLong = errors.New("context too long")
 	ErrUserNotFound   = errors.New("user not found")
+	ErrNoModel        = errors.New("no model available")
+	ErrNotGroupAdmin  = errors.New("not a group admin")
 	ErrsPersistent    = []error{
 		ErrContextTooLong,
 	}
@@ -57,30 +58,89 @@ type Completion struct {
 	TotalTokens      int
 }
 
+// Config contains the operator-defined settings of the bot.
+type Config struct {
+	// ChatModel is the chat completion model used unless the user has chosen another one.
+	ChatModel string
+	// Models are the additional chat models that users can choose with /model.
+	Models []string
+	// ModelAccess restricts models to specific users.
+	ModelAccess ModelAccess
+	// Fallbacks are the models tried in order when the requested one is unavailable.
+	Fallbacks ModelFallbacks
+	// ContextWindows are the context sizes of the chat models.
+	ContextWindows ContextWindows
+	// ReplyTokens is the part of the context window reserved for the reply.
+	ReplyTokens int
+	// DialogRetention is the time of inactivity after which dialogs expire,
+	// unless the user has chosen otherwise. Zero means that dialogs never expire.
+	DialogRetention time.Duration
+	// SummaryModel is the chat model used to summarize long dialogs.
+	// Summarization is disabled if empty.
+	SummaryModel string
+	// AudioModel is the audio transcription model.
+	AudioModel string
+	// Prices is used to estimate the cost of the usage.
+	Prices PriceTable
+	// Quotas limit the usage of every user and the whole deployment.
+	Quotas Quotas
+}
+
 type BotHandler struct {
-	ctx      context.Context
-	bot      *telebot.Bot
-	ai       *openai.Client
-	s        store.Store
-	e        Cryptor
-	m        *sync.RWMutex
-	stopping *atomic.Bool
+	ctx         context.Context
+	bot         *telebot.Bot
+	chat        ChatProvider
+	transcriber Transcriber
+	s           store.Store
+	e           Cryptor
+	cfg         *Config
+	m           *sync.RWMutex
+	stopping    *atomic.Bool
+	streams     *streamRegistry
+	limiter     *ybot.Limiter
 }
 
-func NewBotHandler(ctx context.Context, openAiClient *openai.Client, st store.Store, e Cryptor) *BotHandler {
+func NewBotHandler(ctx context.Context, chat ChatProvider, tr Transcriber, st store.Store, e Cryptor, cfg *Config) *BotHandler {
 	return &BotHandler{
-		ctx:      ctx,
-		ai:       openAiClient,
-		s:        st,
-		e:        e,
-		m:        &sync.RWMutex{},
-		stopping: &atomic.Bool{},
+		ctx:         ctx,
+		chat:        chat,
+		transcriber: tr,
+		s:           st,
+		e:           e,
+		cfg:         cfg,
+		m:           &sync.RWMutex{},
+		stopping:    &atomic.Bool{},
+		streams:     newStreamRegistry(),
+		limiter:     ybot.NewLimiter(),
 	}
 }
 
 func (b *BotHandler) Configure(bot *telebot.Bot) {
 	b.bot = bot
 
+	commandHandlers := []struct {
+		text    string
+		handler telebot.HandlerFunc
+		tag     string
+	}{
+		{"/start", b.CommandHelp, "start"},
+		{"/help", b.CommandHelp, "help"},
+		{"/invite", b.CommandInvite, "invite"},
+		{"/reset", b.CommandReset, "reset"},
+		{"/prompt", b.CommandSystemPrompt, "system_prompt"},
+		{"/model", b.CommandModel, "model"},
+		{"/history", b.CommandHistory, "history"},
+		{"/resume", b.CommandResume, "resume"},
+		{"/summary", b.CommandSummary, "summary"},
+		{"/settings", b.CommandSettings, "settings"},
+		{"/usage", b.CommandUsage, "usage"},
+	}
+	// Other texts starting with a slash are not addressed to the bot in group chats.
+	known := make([]string, len(commandHandlers))
+	for i, cmd := range commandHandlers {
+		known[i] = cmd.text
+	}
+
 	// # Middleware
 
 	for _, lang := range []string{"en", "ru"} {
@@ -102,12 +162,39 @@ func (b *BotHandler) Configure(bot *telebot.Bot) {
 				Text:        "prompt",
 				Description: loc.SystemPromptCommand(),
 			},
+			{
+				Text:        "model",
+				Description: loc.ModelBotCommand(),
+			},
+			{
+				Text:        "history",
+				Description: loc.HistoryBotCommand(),
+			},
+			{
+				Text:        "resume",
+				Description: loc.ResumeBotCommand(),
+			},
+			{
+				Text:        "summary",
+				Description: loc.SummaryBotCommand(),
+			},
+			{
+				Text:        "settings",
+				Description: loc.SettingsBotCommand(),
+			},
+			{
+				Text:        "usage",
+				Description: loc.UsageBotCommand(),
+			},
 		}
 		if err := bot.SetCommands(commands, lang); err != nil {
 			slog.Error("SetCommands", ylog.Err(err), slog.String("lang", lang))
 		}
 	}
 
+	// The forum topic is added to the context before ErrorHandler, so that the errors are sent into it too.
+	bot.Use(ybot.InThread)
+
 	// ErrorHandler must be the first to catch any possible errors
 	// from other middlewares and reply to the user.
 	bot.Use(ErrorHandler())
@@ -125,9 +212,15 @@ func (b *BotHandler) Configure(bot *telebot.Bot) {
 			return next(c)
 		}
 	})
+	bot.Use(ybot.AddressedOnly(bot, known))
 	bot.Use(ybot.TakeMutex(b.m))
+	// The updates are processed one at a time per chat or forum topic, since group members share the dialog.
 	bot.Use(ybot.Sequential(func(c telebot.Context) string {
-		return fmt.Sprintf("%d", c.Sender().ID)
+		// The stop button must not wait for the completion it is supposed to stop.
+		if cb := c.Callback(); cb != nil && cb.Unique == stopGenerationButton {
+			return ""
+		}
+		return fmt.Sprintf("%d/%d", c.Chat().ID, ybot.ThreadID(c))
 	}))
 
 	bot.Use(ybot.AddCtx(b.ctx))
@@ -138,19 +231,27 @@ func (b *BotHandler) Configure(bot *telebot.Bot) {
 	bot.Handle(&telebot.Btn{Unique: "reset_chat_context"}, b.CommandReset, ybot.AddTag("reset_button"))
 	bot.Handle(&telebot.Btn{Unique: "cancel_state"}, b.ClearInputState, ybot.AddTag("cancel_state_button"))
 	bot.Handle(&telebot.Btn{Unique: "set_default_system_prompt"}, b.SetDefaultSystemPrompt, ybot.AddTag("set_default_system_prompt_button"))
+	bot.Handle(&telebot.Btn{Unique: "set_model"}, b.SetModel, ybot.AddTag("set_model_button"))
+	bot.Handle(&telebot.Btn{Unique: "history_page"}, b.HistoryPage, ybot.AddTag("history_page_button"))
+	bot.Handle(&telebot.Btn{Unique: "keep_history"}, b.SetKeepHistory, ybot.AddTag("keep_history_button"))
+	bot.Handle(&telebot.Btn{Unique: "resume_dialog"}, b.ResumeDialog, ybot.AddTag("resume_dialog_button"))
+	bot.Handle(&telebot.Btn{Unique: "set_retention"}, b.SetDialogRetention, ybot.AddTag("set_retention_button"))
+	bot.Handle(&telebot.Btn{Unique: stopGenerationButton}, b.StopGeneration, ybot.AddTag("stop_generation_button"))
+
+	for _, cmd := range commandHandlers {
+		bot.Handle(cmd.text, cmd.handler, ybot.AddTag(cmd.tag))
+	}
 
-	bot.Handle("/start", b.CommandHelp, ybot.AddTag("start"))
-	bot.Handle("/help", b.CommandHelp, ybot.AddTag("help"))
-	bot.Handle("/invite", b.CommandInvite, ybot.AddTag("invite"))
-	bot.Handle("/reset", b.CommandReset, ybot.AddTag("reset"))
-	bot.Handle("/prompt", b.CommandSystemPrompt, ybot.AddTag("system_prompt"))
+	quota := CheckQuota(b.s, b.cfg)
 
-	bot.Handle(telebot.OnText, b.Text, ybot.AddTag("chat_completion"))
-	bot.Handle(telebot.OnVoice, b.TranscribeVoice, ybot.AddTag("transcribe_voice"))
-	bot.Handle(telebot.OnAudio, b.TranscribeAudio, ybot.AddTag("transcribe_audio"))
-	bot.Handle(telebot.OnVideo, b.TranscribeVideo, ybot.AddTag("transcribe_video"))
-	bot.Handle(telebot.OnVideoNote, b.TranscribeVideoNote, ybot.AddTag("transcribe_video_note"))
-	bot.Handle(telebot.OnDocument, b.TranscribeDocument, ybot.AddTag("transcribe_document"))
+	bot.Handle(telebot.OnText, b.Text, ybot.AddTag("chat_completion"), quota)
+	bot.Handle(telebot.OnEdited, b.EditedText, ybot.AddTag("edited_completion"), quota)
+	bot.Handle(&telebot.Btn{Unique: "regenerate"}, b.Regenerate, ybot.AddTag("regenerate_button"), quota)
+	bot.Handle(telebot.OnVoice, b.TranscribeVoice, ybot.AddTag("transcribe_voice"), quota)
+	bot.Handle(telebot.OnAudio, b.TranscribeAudio, ybot.AddTag("transcribe_audio"), quota)
+	bot.Handle(telebot.OnVideo, b.TranscribeVideo, ybot.AddTag("transcribe_video"), quota)
+	bot.Handle(telebot.OnVideoNote, b.TranscribeVideoNote, ybot.AddTag("transcribe_video_note"), quota)
+	bot.Handle(telebot.OnDocument, b.TranscribeDocument, ybot.AddTag("transcribe_document"), quota)
 
 	bot.Handle(telebot.OnMedia, b.Unsupported, ybot.AddTag("media"))
 }
@@ -194,10 +295,7 @@ func (b *BotHandler) CommandReset(c telebot.Context) error {
 		return ErrUserNotFound
 	}
 
-	if err := b.s.ClearMessages(ctx, user.ChatId); err != nil {
-		return err
-	}
-	if err := b.s.ResetDiglogID(ctx, user); err != nil {
+	if err := b.newDialog(ctx, user); err != nil {
 		return err
 	}
 
@@ -205,6 +303,20 @@ func (b *BotHandler) CommandReset(c telebot.Context) error {
 	return c.Send(loc.ResetMessage())
 }
 
+// newDialog starts a new dialog. The current one is kept in the history
+// if the user has chosen so, and deleted otherwise.
+func (b *BotHandler) newDialog(ctx context.Context, user *store.User) error {
+	if !user.KeepHistory {
+		if err := b.s.ClearMessages(ctx, user); err != nil {
+			return fmt.Errorf("ClearMessages: %w", err)
+		}
+	}
+	if err := b.s.ResetDiglogID(ctx, user); err != nil {
+		return fmt.Errorf("ResetDiglogID: %w", err)
+	}
+	return nil
+}
+
 func (b *BotHandler) ClearInputState(c telebot.Context) error {
 	ctx := ybot.Ctx(c)
 	user, ok := c.Get(ctxKeyUser).(*store.User)
@@ -212,7 +324,7 @@ func (b *BotHandler) ClearInputState(c telebot.Context) error {
 		return ErrUserNotFound
 	}
 
-	if err := b.s.SetInputState(ctx, user.ChatId, store.InputStateEmpty); err != nil {
+	if err := b.s.SetInputState(ctx, user, store.InputStateEmpty); err != nil {
 		return err
 	}
 
@@ -236,7 +348,7 @@ func (b *BotHandler) CommandSystemPrompt(c telebot.Context) error {
 		currentPrompt = loc.InitialSystemPrompt()
 	}
 
-	if err := b.s.SetInputState(ctx, user.ChatId, store.InputStateWaitingForSystemPrompt); err != nil {
+	if err := b.s.SetInputState(ctx, user, store.InputStateWaitingForSystemPrompt); err != nil {
 		return fmt.Errorf("set state: %w", err)
 	}
 
@@ -256,6 +368,156 @@ func (b *BotHandler) CommandSystemPrompt(c telebot.Context) error {
 	})
 }
 
+func (b *BotHandler) CommandModel(c telebot.Context) error {
+	user, ok := c.Get(ctxKeyUser).(*store.User)
+	if !ok {
+		return ErrUserNotFound
+	}
+	loc := locale.New(ybot.Lang(c))
+
+	currentModel := b.userModel(user)
+	if currentModel == "" {
+		return c.Send(loc.ModelNotAvailableMessage())
+	}
+
+	var menuItems []string
+	for _, m := range b.cfg.UserModels(user.ChatId) {
+		text := m
+		if m == currentModel {
+			text = "✅ " + m
+		}
+		menuItems = append(menuItems, "set_model|"+m, text)
+	}
+
+	return c.Send(loc.ModelMessage(currentModel), &telebot.SendOptions{
+		ParseMode:   telebot.ModeHTML,
+		ReplyMarkup: ybot.MultiButtonMenu(menuItems...),
+	})
+}
+
+func (b *BotHandler) SetModel(c telebot.Context) error {
+	ctx := ybot.Ctx(c)
+	user, ok := c.Get(ctxKeyUser).(*store.User)
+	if !ok {
+		return ErrUserNotFound
+	}
+	loc := locale.New(ybot.Lang(c))
+
+	model := c.Data()
+	if !b.cfg.ModelAllowed(user.ChatId, model) {
+		return c.Send(loc.ModelNotAvailableMessage())
+	}
+	if model == b.userModel(user) {
+		return c.Send(loc.ModelUnchangedMessage())
+	}
+
+	// The default model is not persisted, so that the user follows
+	// the operator's choice if it changes.
+	userModel := model
+	if userModel == b.cfg.ChatModel {
+		userModel = ""
+	}
+
+	if err := b.s.SetModel(ctx, user, userModel); err != nil {
+		return fmt.Errorf("SetModel: %w", err)
+	}
+
+	return c.Send(loc.ModelUpdatedMessage(model), &telebot.SendOptions{ParseMode: telebot.ModeHTML})
+}
+
+// userModel returns the model chosen by the user, or the first one available to them,
+// starting with the default. It is empty if the user is not allowed any model.
+func (b *BotHandler) userModel(user *store.User) string {
+	if user.Model != "" && b.cfg.ModelAllowed(user.ChatId, user.Model) {
+		return user.Model
+	}
+	if models := b.cfg.UserModels(user.ChatId); len(models) > 0 {
+		return models[0]
+	}
+	return ""
+}
+
+func (b *BotHandler) CommandUsage(c telebot.Context) error {
+	ctx := ybot.Ctx(c)
+	user, ok := c.Get(ctxKeyUser).(*store.User)
+	if !ok {
+		return ErrUserNotFound
+	}
+	loc := locale.New(ybot.Lang(c))
+
+	var buf strings.Builder
+	buf.WriteString(loc.UsageMessage())
+
+	// The usage of a group is shared by its members, while the user's own usage
+	// includes the one in the groups, like the quota.
+	getUsage := func(since time.Time) ([]*store.UsageSummary, error) {
+		if ybot.IsGroup(c) {
+			return b.s.GetUsage(ctx, user.ChatId, since)
+		}
+		return b.s.GetSenderUsage(ctx, c.Sender().ID, since)
+	}
+
+	now := time.Now()
+	for _, period := range usagePeriods {
+		usage, err := getUsage(period.Start(now))
+		if err != nil {
+			return fmt.Errorf("GetUsage: %w", err)
+		}
+
+		buf.WriteString("\n\n")
+		buf.WriteString(b.formatUsage(loc, period, usage))
+	}
+
+	return c.Send(buf.String(), &telebot.SendOptions{ParseMode: telebot.ModeHTML})
+}
+
+func (b *BotHandler) formatUsage(loc *locale.Locale, period usagePeriod, usage []*store.UsageSummary) string {
+	var title string
+	switch period {
+	case usagePeriodToday:
+		title = loc.UsageTodayTitle()
+	case usagePeriodWeek:
+		title = loc.UsageWeekTitle()
+	case usagePeriodMonth:
+		title = loc.UsageMonthTitle()
+	case usagePeriodTotal:
+		title = loc.UsageTotalTitle()
+	}
+
+	lines := []string{"<b>" + title + "</b>"}
+	if len(usage) == 0 {
+		return strings.Join(append(lines, loc.UsageEmpty()), "\n")
+	}
+
+	var (
+		totalTokens int
+		totalCost   float64
+	)
+	for _, u := range usage {
+		cost := b.cfg.Prices.Cost(u)
+		totalTokens += u.TotalTokens
+		totalCost += cost
+		if u.AudioSeconds > 0 && u.TotalTokens == 0 {
+			lines = append(lines, loc.UsageAudioLine(html.EscapeString(u.Model), formatMinutes(u.AudioSeconds), formatCost(cost)))
+			continue
+		}
+		lines = append(lines, loc.UsageModelLine(html.EscapeString(u.Model), u.TotalTokens, formatCost(cost)))
+	}
+	if len(usage) > 1 {
+		lines = append(lines, loc.UsageTotalLine(totalTokens, formatCost(totalCost)))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func formatCost(cost float64) string {
+	return fmt.Sprintf("%.4f", cost)
+}
+
+func formatMinutes(seconds int) string {
+	return fmt.Sprintf("%.1f", float64(seconds)/60)
+}
+
 func (b *BotHandler) TranscribeDocument(c telebot.Context) error {
 	return b.transcribe(c, &c.Message().Document.File, false)
 }
@@ -280,6 +542,11 @@ func (b *BotHandler) transcribe(c telebot.Context, file *telebot.File, completio
 	ctx := ybot.Ctx(c)
 	logger := ybot.Logger(c)
 
+	// Transcription is only available with OpenAI.
+	if b.transcriber == nil {
+		return c.Send(locale.New(ybot.Lang(c)).TranscriptionUnavailableMessage())
+	}
+
 	isForwarded := c.Message().OriginalUnixtime != 0
 
 	cancelNotify := ybot.NotifyTyping(ctx, c)
@@ -320,12 +587,17 @@ func (b *BotHandler) transcribe(c telebot.Context, file *telebot.File, completio
 		return fmt.Errorf("convert voice message: %w", err)
 	}
 
-	resp, err := b.ai.CreateTranscription(ctx, openai.AudioRequest{
-		Model:    openai.Whisper1,
-		FilePath: mp3FilePath,
-	})
+	resp, err := b.transcriber.Transcribe(ctx, b.cfg.AudioModel, mp3FilePath)
 	if err != nil {
-		return fmt.Errorf("CreateTranscription: %w", err)
+		return fmt.Errorf("Transcribe: %w", err)
+	}
+
+	// Unlike the chat models, the transcription is billed by the duration of the audio.
+	if err := b.recordUsage(ctx, c, &store.Usage{
+		Model:        resp.Model,
+		AudioSeconds: int(math.Ceil(resp.Duration.Seconds())),
+	}); err != nil {
+		return err
 	}
 
 	loc := locale.New(ybot.Lang(c))
@@ -357,12 +629,17 @@ func (b *BotHandler) Text(c telebot.Context) error {
 		return ErrUserNotFound
 	}
 
+	text := ybot.StripMention(c.Message().Text, b.bot.Me.Username)
+	if text == "" {
+		return nil
+	}
+
 	switch user.InputState {
 	case store.InputStateEmpty:
-		return b.doCompletion(ctx, c, c.Message().Text)
+		return b.doCompletion(ctx, c, text)
 
 	case store.InputStateWaitingForSystemPrompt:
-		return b.doSetSystemPrompt(c, c.Message().Text)
+		return b.doSetSystemPrompt(c, text)
 	}
 
 	return nil
@@ -380,16 +657,16 @@ func (b *BotHandler) doSetSystemPrompt(c telebot.Context, prompt string) error {
 		return c.Send(loc.SystemPromptUnchanged())
 	}
 
-	if err := b.s.SetSystemPrompt(ctx, user.ChatId, prompt); err != nil {
+	if err := b.s.SetSystemPrompt(ctx, user, prompt); err != nil {
 		return fmt.Errorf("SetSystemPrompt: %w", err)
 	}
 
-	if err := b.s.SetInputState(ctx, user.ChatId, store.InputStateEmpty); err != nil {
+	if err := b.s.SetInputState(ctx, user, store.InputStateEmpty); err != nil {
 		return fmt.Errorf("SetInputState: %w", err)
 	}
 
-	if err := b.s.ClearMessages(ctx, user.ChatId); err != nil {
-		return fmt.Errorf("ClearMessages: %w", err)
+	if err := b.newDialog(ctx, user); err != nil {
+		return err
 	}
 
 	displayPrompt := prompt
@@ -403,7 +680,6 @@ func (b *BotHandler) doSetSystemPrompt(c telebot.Context, prompt string) error {
 }
 
 func (b *BotHandler) doCompletion(ctx context.Context, c telebot.Context, text string) error {
-	logger := ybot.Logger(c)
 	user, ok := c.Get(ctxKeyUser).(*store.User)
 	if !ok {
 		return ErrUserNotFound
@@ -411,54 +687,260 @@ func (b *BotHandler) doCompletion(ctx context.Context, c telebot.Context, text s
 	loc := locale.New(ybot.Lang(c))
 
 	var (
-		reqMsgs []openai.ChatCompletionMessage
+		reqMsgs []*store.Message
 		msgs    []*store.Message
 	)
 
-	previousMsgs, err := b.s.GetDialogMessages(ctx, user.ChatId)
+	if err := b.forkDialog(ctx, c, user); err != nil {
+		return err
+	}
+
+	previousMsgs, expired, err := b.s.GetDialogMessages(ctx, user)
 	if err != nil {
 		return err
 	}
 
+	if expired {
+		notice := loc.DialogExpiredMessage(formatRetention(loc, b.dialogRetention(user)))
+		if user.KeepHistory {
+			notice += " " + loc.DialogExpiredResumeHint()
+		}
+		err := b.limiter.Do(ctx, c.Chat().ID, func() error {
+			_, err := ybot.Send(c, notice)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	previousMsgs = dialogContext(previousMsgs, len(previousMsgs))
 	for _, msg := range previousMsgs {
 		if err := b.e.DecryptMessage(user, msg); err != nil {
 			return fmt.Errorf("message id=%d DecryptMessage: %w", msg.Id, err)
 		}
 	}
 
+	model := b.userModel(user)
+	if model == "" {
+		return ErrNoModel
+	}
+
+	previousMsgs, err = b.summarize(ctx, c, user, model, previousMsgs)
+	if err != nil {
+		return err
+	}
+
 	if len(previousMsgs) > 0 {
-		reqMsgs = messagesToOpenAiMessages(previousMsgs)
+		reqMsgs = requestMessages(loc, previousMsgs)
 	} else {
 		systemPrompt := user.SystemPrompt
 		if systemPrompt == "" {
 			systemPrompt = loc.InitialSystemPrompt()
 		}
+		if ybot.IsGroup(c) {
+			systemPrompt += "\n\n" + loc.GroupSystemPrompt()
+		}
 
 		msgs = append(msgs, &store.Message{
-			ChatId:  user.ChatId,
-			Role:    openai.ChatMessageRoleSystem,
-			Message: systemPrompt,
+			ChatId:   user.ChatId,
+			DialogID: user.DialogID,
+			ThreadID: user.ThreadID,
+			Role:     store.RoleSystem,
+			Message:  systemPrompt,
 		})
 	}
 
 	msgs = append(msgs, &store.Message{
-		ChatId:  user.ChatId,
-		Role:    openai.ChatMessageRoleUser,
-		Message: text,
+		ChatId:     user.ChatId,
+		DialogID:   user.DialogID,
+		ThreadID:   user.ThreadID,
+		Role:       store.RoleUser,
+		Message:    promptText(c, text),
+		TelegramID: c.Message().ID,
 	})
 
-	reqMsgs = append(reqMsgs, messagesToOpenAiMessages(msgs)...)
+	reqMsgs = append(reqMsgs, msgs...)
+
+	reply, err := b.startReply(ctx, c, loc)
+	if err != nil {
+		return err
+	}
+
+	completion, err := b.generate(ctx, c, reply, model, reqMsgs)
+	if err != nil {
+		return err
+	}
+
+	assistantMsg := assistantMessage(user, completion, reply)
+	msgs = append(msgs, assistantMsg)
 
-	model := user.Model
+	for _, msg := range msgs {
+		if err := b.e.EncryptMessage(user, msg); err != nil {
+			return fmt.Errorf("message encrypt: %w", err)
+		}
+	}
+
+	if err := b.s.PutMessages(ctx, msgs); err != nil {
+		return fmt.Errorf("put messages: %w", err)
+	}
+
+	b.addReplyMenu(c, reply, assistantMsg.Id)
+
+	return b.putUsage(ctx, c, completion)
+}
+
+// generate streams the completion of the dialog into the reply,
+// switching to the fallback models if the requested one is unavailable.
+func (b *BotHandler) generate(ctx context.Context, c telebot.Context, reply *ybot.Reply, model string, reqMsgs []*store.Message) (*Completion, error) {
+	logger := ybot.Logger(c)
+	loc := locale.New(ybot.Lang(c))
+
+	user, ok := c.Get(ctxKeyUser).(*store.User)
+	if !ok {
+		return nil, ErrUserNotFound
+	}
 	if model == "" {
-		model = defaultGptModel
+		return nil, ErrNoModel
+	}
+
+	chain := b.cfg.ModelChain(user.ChatId, model)
+
+	for i, m := range chain {
+		req := &ChatRequest{
+			Model:    m,
+			User:     gptUser,
+			Messages: TrimMessages(m, reqMsgs, b.cfg.PromptBudget(m)),
+		}
+
+		var footnote string
+		if m != model {
+			footnote = loc.FallbackFootnote(m)
+		}
+
+		canFailover := i < len(chain)-1
+		completion, err := b.complete(ctx, c, reply, req, footnote, canFailover)
+		if err == nil {
+			return completion, nil
+		}
+		if !canFailover || !errors.Is(err, ErrProviderUnavailable) {
+			return nil, err
+		}
+
+		logger.LogAttrs(ctx, slog.LevelWarn, "completion failover",
+			slog.String("model", m),
+			slog.String("fallback_model", chain[i+1]),
+			ylog.Err(err),
+		)
+	}
+
+	return nil, ErrProviderUnavailable
+}
+
+// assistantMessage makes the dialog message of the completion shown in the reply.
+func assistantMessage(user *store.User, completion *Completion, reply *ybot.Reply) *store.Message {
+	ids := reply.IDs()
+	return &store.Message{
+		ChatId:        user.ChatId,
+		DialogID:      user.DialogID,
+		ThreadID:      user.ThreadID,
+		Role:          store.RoleAssistant,
+		Message:       completion.Response,
+		TelegramID:    ids[0],
+		TelegramParts: ids[1:],
 	}
+}
 
-	req := openai.ChatCompletionRequest{
-		Model:    model,
-		User:     gptUser,
-		Messages: reqMsgs,
+// storedReply returns the reply with the Telegram messages of the assistant message.
+func storedReply(chatId int64, msg *store.Message) *ybot.Reply {
+	reply := ybot.NewReply()
+	for _, id := range append([]int{msg.TelegramID}, msg.TelegramParts...) {
+		reply.Parts = append(reply.Parts, &telebot.StoredMessage{MessageID: strconv.Itoa(id), ChatID: chatId})
 	}
+	return reply
+}
+
+// promptText tags the text with the name of the speaker in group chats,
+// so that the model can tell the members of the group apart.
+func promptText(c telebot.Context, text string) string {
+	if !ybot.IsGroup(c) {
+		return text
+	}
+	return ybot.SpeakerName(c.Sender()) + ": " + text
+}
+
+// replyOptions returns the options of the reply placeholder. In group chats,
+// the reply is attached to the message of the speaker.
+func replyOptions(c telebot.Context, loc *locale.Locale) *telebot.SendOptions {
+	return &telebot.SendOptions{ReplyMarkup: stopMenu(loc, nil), ReplyTo: promptMessage(c)}
+}
+
+// promptMessage returns the message the reply is for in group chats: the addressed
+// message, or the one replied to by the message of the pressed button.
+func promptMessage(c telebot.Context) *telebot.Message {
+	if !ybot.IsGroup(c) {
+		return nil
+	}
+	if cb := c.Callback(); cb != nil {
+		return cb.Message.ReplyTo
+	}
+	return c.Message()
+}
+
+// startReply sends the placeholder of the reply within the rate limits.
+func (b *BotHandler) startReply(ctx context.Context, c telebot.Context, loc *locale.Locale) (*ybot.Reply, error) {
+	var placeholder *telebot.Message
+	err := b.limiter.Do(ctx, c.Chat().ID, func() (err error) {
+		placeholder, err = ybot.Send(c, "...", replyOptions(c, loc))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ybot.NewReply(placeholder), nil
+}
+
+// restartReply turns the existing reply back into the placeholder within the rate limits.
+func (b *BotHandler) restartReply(ctx context.Context, c telebot.Context, loc *locale.Locale, reply *ybot.Reply) error {
+	return b.limiter.Do(ctx, c.Chat().ID, func() error {
+		_, err := b.bot.Edit(reply.First(), "...", stopMenu(loc, reply.First()))
+		return err
+	})
+}
+
+func (b *BotHandler) putUsage(ctx context.Context, c telebot.Context, r *Completion) error {
+	return b.recordUsage(ctx, c, &store.Usage{
+		Model:            r.Model,
+		CompletionTokens: r.CompletionTokens,
+		PromptTokens:     r.PromptTokens,
+		TotalTokens:      r.TotalTokens,
+	})
+}
+
+// recordUsage stores the usage of the update on behalf of the chat and its sender.
+func (b *BotHandler) recordUsage(ctx context.Context, c telebot.Context, usage *store.Usage) error {
+	user, ok := c.Get(ctxKeyUser).(*store.User)
+	if !ok {
+		return ErrUserNotFound
+	}
+
+	usage.ChatId = user.ChatId
+	if sender := c.Sender(); sender != nil {
+		usage.SenderId = sender.ID
+	}
+	usage.UpdateId = c.Update().ID
+	if err := b.s.PutUsage(ctx, usage); err != nil {
+		return fmt.Errorf("put usage: %w", err)
+	}
+
+	return nil
+}
+
+// complete generates the completion with retries. If canFailover is set,
+// the unavailability of the provider is reported as ErrProviderUnavailable
+// without retries, so that the caller can switch to a fallback model.
+func (b *BotHandler) complete(ctx context.Context, c telebot.Context, reply *ybot.Reply, req *ChatRequest, footnote string, canFailover bool) (*Completion, error) {
+	logger := ybot.Logger(c)
 
 	backoff := &strategy.Backoff{
 		Duration: backoffDuration,
@@ -467,15 +949,16 @@ func (b *BotHandler) doCompletion(ctx context.Context, c telebot.Context, text s
 		Jitter:   true,
 	}
 
-	reply, err := b.bot.Send(c.Recipient(), "...")
-	if err != nil {
-		return err
+	persistentErrs := append([]error{}, ErrsPersistent...)
+	if canFailover {
+		persistentErrs = append(persistentErrs, ErrProviderUnavailable)
 	}
 
 	var completion *Completion
 	completeFunc := func() error {
 		attrs := []slog.Attr{
-			slog.Int("context_length", len(reqMsgs)),
+			slog.String("requested_model", req.Model),
+			slog.Int("context_length", len(req.Messages)),
 		}
 		level := slog.LevelDebug
 		defer func() {
@@ -484,16 +967,21 @@ func (b *BotHandler) doCompletion(ctx context.Context, c telebot.Context, text s
 
 		start := time.Now()
 
-		r, cErr := b.makeStreamCompletion(ctx, reply, &req)
+		r, cErr := b.makeStreamCompletion(ctx, c, reply, req, footnote)
 
 		attrs = append(attrs, slog.Duration("duration", time.Since(start)))
 
 		if cErr != nil {
 			attrs = append(attrs, ylog.Err(cErr))
 			level = slog.LevelError
-			if strings.Contains(cErr.Error(), "reduce the length of the messages") {
+			if errors.Is(cErr, ErrContextTooLong) {
 				return ErrContextTooLong
 			}
+			// The completion timed out while the bot is still running.
+			timeout := ctx.Err() == nil && (errors.Is(cErr, context.DeadlineExceeded) || errors.Is(cErr, context.Canceled))
+			if canFailover && (timeout || errors.Is(cErr, ErrProviderUnavailable)) {
+				return ErrProviderUnavailable
+			}
 			return cErr
 		}
 
@@ -508,30 +996,19 @@ func (b *BotHandler) doCompletion(ctx context.Context, c telebot.Context, text s
 		return nil
 	}
 
-	if err := repeater.New(backoff).Do(ctx, completeFunc, ErrsPersistent...); err != nil {
-		return err
-	}
-
-	msgs = append(msgs, &store.Message{
-		ChatId:  user.ChatId,
-		Role:    openai.ChatMessageRoleAssistant,
-		Message: completion.Response,
-	})
-
-	for _, msg := range msgs {
-		if err := b.e.EncryptMessage(user, msg); err != nil {
-			return fmt.Errorf("message encrypt: %w", err)
-		}
-	}
-
-	if err := b.s.PutMessages(ctx, msgs); err != nil {
-		return fmt.Errorf("put messages: %w", err)
+	if err := repeater.New(backoff).Do(ctx, completeFunc, persistentErrs...); err != nil {
+		return nil, err
 	}
 
-	return nil
+	return completion, nil
 }
 
-func (b *BotHandler) makeStreamCompletion(ctx context.Context, responseMsg telebot.Editable, req *openai.ChatCompletionRequest) (*Completion, error) {
+// makeStreamCompletion streams a single completion into the reply. The messages of the reply
+// are reused by the next attempt. If the user stops the generation, the partial response
+// is returned as the completion.
+func (b *BotHandler) makeStreamCompletion(ctx context.Context, c telebot.Context, reply *ybot.Reply, req *ChatRequest, footnote string) (*Completion, error) {
+	loc := locale.New(ybot.Lang(c))
+
 	ctx, cancel := context.WithTimeout(ctx, completionTotalTimeout)
 	defer cancel()
 
@@ -540,54 +1017,29 @@ func (b *BotHandler) makeStreamCompletion(ctx context.Context, responseMsg teleb
 	})
 	defer hb.Close()
 
-	writer := ybot.NewWriter(hb.Ctx(), b.bot, responseMsg)
-
-	completion := &Completion{}
+	streamCtx, done := b.streams.Start(hb.Ctx(), reply.First())
+	writer := ybot.NewWriter(streamCtx, b.bot, b.limiter, reply, &telebot.SendOptions{ThreadID: ybot.ThreadID(c), ReplyTo: promptMessage(c)}, stopMenu(loc, reply.First()))
 
-	g, ctx := errgroup.WithContext(hb.Ctx())
-	g.Go(func() error {
-		stream, err := b.ai.CreateChatCompletionStream(ctx, *req)
-		if err != nil {
-			return fmt.Errorf("CreateChatCompletion: %w", err)
-		}
-		defer writer.Close()
-		defer stream.Close()
-
-		for {
-			response, err := stream.Recv()
-			if err != nil {
-				if errors.Is(err, io.EOF) {
-					break
-				}
-				return fmt.Errorf("stream.Recv: %w", err)
-			}
+	completion, err := b.chat.ChatStream(streamCtx, req, func(delta string) {
+		writer.Write(delta)
+		hb.Beat()
+	})
 
-			completion.Model = response.Model
-			if len(response.Choices) > 0 {
-				writer.Write(response.Choices[0].Delta.Content)
-				hb.Beat()
-			}
-		}
+	if stopped := done(); stopped && err != nil {
+		return b.stoppedCompletion(writer, req, loc), nil
+	}
 
-		return nil
-	})
+	// The footnote is only displayed and does not become a part of the dialog.
+	if err == nil && footnote != "" {
+		writer.Write("\n\n" + footnote)
+	}
+	// The placeholder message keeps its text if nothing has been generated,
+	// so that the request can be retried, but it loses the stop button.
+	writer.Close()
 
-	if err := g.Wait(); err != nil {
+	if err != nil {
 		return nil, err
 	}
 
-	completion.Response = writer.String()
-
 	return completion, nil
 }
-
-func messagesToOpenAiMessages(messages []*store.Message) []openai.ChatCompletionMessage {
-	res := make([]openai.ChatCompletionMessage, len(messages))
-	for i, m := range messages {
-		res[i] = openai.ChatCompletionMessage{
-			Role:    m.Role,
-			Content: m.Message,
-		}
-	}
-	return res
-}
@@ -0,0 +1,256 @@
+package jeepity
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/mkuznets/telebot/v3"
+	"golang.org/x/exp/slog"
+
+	"mkuznets.com/go/jeepity/internal/locale"
+	"mkuznets.com/go/jeepity/internal/store"
+	"mkuznets.com/go/jeepity/internal/ybot"
+)
+
+const (
+	historyPageSize    = 5
+	resumeDialogsLimit = 5
+	historyTitleLength = 48
+	historyTimeLayout  = "2006-01-02 15:04 MST"
+	resumeTimeLayout   = "2006-01-02"
+)
+
+func (b *BotHandler) CommandHistory(c telebot.Context) error {
+	text, menu, err := b.historyPage(c, 0)
+	if err != nil {
+		return err
+	}
+	return c.Send(text, &telebot.SendOptions{
+		ParseMode:   telebot.ModeHTML,
+		ReplyMarkup: menu,
+	})
+}
+
+// HistoryPage switches the page of the /history message.
+func (b *BotHandler) HistoryPage(c telebot.Context) error {
+	page, err := strconv.Atoi(c.Data())
+	if err != nil {
+		return fmt.Errorf("invalid history page %q: %w", c.Data(), err)
+	}
+
+	text, menu, err := b.historyPage(c, page)
+	if err != nil {
+		return err
+	}
+	return c.Edit(text, &telebot.SendOptions{
+		ParseMode:   telebot.ModeHTML,
+		ReplyMarkup: menu,
+	})
+}
+
+// SetKeepHistory enables or disables keeping the past dialogs.
+// Disabling it deletes the dialogs that have been kept so far.
+// In group chats, only the admins can change it, since it applies to the whole group.
+func (b *BotHandler) SetKeepHistory(c telebot.Context) error {
+	ctx := ybot.Ctx(c)
+	user, ok := c.Get(ctxKeyUser).(*store.User)
+	if !ok {
+		return ErrUserNotFound
+	}
+	if err := requireGroupAdmin(c); err != nil {
+		return err
+	}
+
+	keep := c.Data() == "1"
+	if err := b.s.SetKeepHistory(ctx, user, keep); err != nil {
+		return fmt.Errorf("SetKeepHistory: %w", err)
+	}
+	user.KeepHistory = keep
+
+	if !keep {
+		if err := b.s.ClearPastDialogs(ctx, user); err != nil {
+			return fmt.Errorf("ClearPastDialogs: %w", err)
+		}
+	}
+
+	text, menu, err := b.historyPage(c, 0)
+	if err != nil {
+		return err
+	}
+	return c.Edit(text, &telebot.SendOptions{
+		ParseMode:   telebot.ModeHTML,
+		ReplyMarkup: menu,
+	})
+}
+
+func (b *BotHandler) CommandResume(c telebot.Context) error {
+	ctx := ybot.Ctx(c)
+	user, ok := c.Get(ctxKeyUser).(*store.User)
+	if !ok {
+		return ErrUserNotFound
+	}
+	loc := locale.New(ybot.Lang(c))
+
+	dialogs, err := b.s.GetPastDialogs(ctx, user, 0, resumeDialogsLimit)
+	if err != nil {
+		return fmt.Errorf("GetPastDialogs: %w", err)
+	}
+	if len(dialogs) == 0 {
+		return c.Send(loc.ResumeEmpty())
+	}
+
+	var rows [][]string
+	for _, d := range dialogs {
+		title, err := b.dialogTitle(loc, user, d)
+		if err != nil {
+			return err
+		}
+		text := d.CreatedAt.UTC().Format(resumeTimeLayout) + " · " + title
+		rows = append(rows, []string{"resume_dialog|" + d.DialogID, text})
+	}
+
+	return c.Send(loc.ResumeMessage(), ybot.MultiRowMenu(rows...))
+}
+
+// ResumeDialog makes the chosen past dialog the current one.
+func (b *BotHandler) ResumeDialog(c telebot.Context) error {
+	ctx := ybot.Ctx(c)
+	user, ok := c.Get(ctxKeyUser).(*store.User)
+	if !ok {
+		return ErrUserNotFound
+	}
+	loc := locale.New(ybot.Lang(c))
+
+	dialogId := c.Data()
+	if dialogId == user.DialogID {
+		return c.Send(loc.ResumeUnchangedMessage())
+	}
+
+	if err := b.s.ResumeDialog(ctx, user, dialogId); err != nil {
+		if errors.Is(err, store.ErrDialogNotFound) {
+			return c.Send(loc.ResumeNotFoundMessage())
+		}
+		return fmt.Errorf("ResumeDialog: %w", err)
+	}
+
+	return c.Send(loc.ResumedMessage())
+}
+
+// forkDialog branches the conversation if the message replies to an earlier reply of the bot:
+// the new current dialog continues from that reply, and the original one stays in the history.
+func (b *BotHandler) forkDialog(ctx context.Context, c telebot.Context, user *store.User) error {
+	replyTo := c.Message().ReplyTo
+	if replyTo == nil || replyTo.Sender == nil || replyTo.Sender.ID != b.bot.Me.ID {
+		return nil
+	}
+
+	forked, err := b.s.ForkDialog(ctx, user, replyTo.ID)
+	if err != nil {
+		// The reply might have been deleted with its dialog.
+		if errors.Is(err, store.ErrMessageNotFound) {
+			return nil
+		}
+		return fmt.Errorf("ForkDialog: %w", err)
+	}
+	if !forked {
+		return nil
+	}
+
+	if !user.KeepHistory {
+		if err := b.s.ClearPastDialogs(ctx, user); err != nil {
+			return fmt.Errorf("ClearPastDialogs: %w", err)
+		}
+	}
+
+	ybot.Logger(c).Debug("dialog forked", slog.Int("reply_id", replyTo.ID))
+	return nil
+}
+
+func (b *BotHandler) historyPage(c telebot.Context, page int) (string, *telebot.ReplyMarkup, error) {
+	ctx := ybot.Ctx(c)
+	user, ok := c.Get(ctxKeyUser).(*store.User)
+	if !ok {
+		return "", nil, ErrUserNotFound
+	}
+	loc := locale.New(ybot.Lang(c))
+
+	total, err := b.s.CountPastDialogs(ctx, user)
+	if err != nil {
+		return "", nil, fmt.Errorf("CountPastDialogs: %w", err)
+	}
+
+	pages := (total + historyPageSize - 1) / historyPageSize
+	if page >= pages {
+		page = pages - 1
+	}
+	if page < 0 {
+		page = 0
+	}
+
+	dialogs, err := b.s.GetPastDialogs(ctx, user, page*historyPageSize, historyPageSize)
+	if err != nil {
+		return "", nil, fmt.Errorf("GetPastDialogs: %w", err)
+	}
+
+	var sb strings.Builder
+	if len(dialogs) == 0 {
+		sb.WriteString(loc.HistoryEmpty())
+	} else {
+		sb.WriteString(loc.HistoryMessage(page+1, pages))
+	}
+
+	for i, d := range dialogs {
+		title, err := b.dialogTitle(loc, user, d)
+		if err != nil {
+			return "", nil, err
+		}
+
+		sb.WriteString("\n\n")
+		sb.WriteString(loc.HistoryDialogLine(
+			page*historyPageSize+i+1,
+			html.EscapeString(title),
+			d.CreatedAt.UTC().Format(historyTimeLayout),
+			d.MessageCount,
+		))
+	}
+
+	var paging []string
+	if page > 0 {
+		paging = append(paging, "history_page|"+strconv.Itoa(page-1), "‹ "+loc.HistoryPrevButton())
+	}
+	if page < pages-1 {
+		paging = append(paging, "history_page|"+strconv.Itoa(page+1), loc.HistoryNextButton()+" ›")
+	}
+
+	keep := []string{"keep_history|1", loc.HistoryKeepButton()}
+	if user.KeepHistory {
+		keep = []string{"keep_history|0", loc.HistoryDontKeepButton()}
+	}
+
+	return sb.String(), ybot.MultiRowMenu(paging, keep), nil
+}
+
+// dialogTitle makes a one-line title of the dialog from its first message.
+func (b *BotHandler) dialogTitle(loc *locale.Locale, user *store.User, d *store.Dialog) (string, error) {
+	if d.FirstMessage == nil {
+		return loc.HistoryUntitled(), nil
+	}
+	if err := b.e.DecryptMessage(user, d.FirstMessage); err != nil {
+		return "", fmt.Errorf("message id=%d DecryptMessage: %w", d.FirstMessage.Id, err)
+	}
+	return truncateTitle(d.FirstMessage.Message), nil
+}
+
+func truncateTitle(text string) string {
+	title := strings.Join(strings.Fields(text), " ")
+	if utf8.RuneCountInString(title) <= historyTitleLength {
+		return title
+	}
+	runes := []rune(title)
+	return strings.TrimSpace(string(runes[:historyTitleLength])) + "…"
+}
@@ -1,9 +1,11 @@
 package jeepity
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/mkuznets/telebot/v3"
 
@@ -12,15 +14,20 @@ import (
 	"mkuznets.com/go/jeepity/internal/ybot"
 )
 
-const ctxKeyUser = "user"
+const (
+	ctxKeyUser = "user"
 
-func extractInviteCode(c telebot.Context) string {
+	quotaResetLayout = "2006-01-02 15:04 MST"
+)
+
+// isStartCommand reports whether the update is the /start command.
+func isStartCommand(c telebot.Context) bool {
 	msg := c.Message()
-	if msg == nil {
-		return ""
-	}
+	return msg != nil && c.Callback() == nil && strings.HasPrefix(msg.Text, "/start")
+}
 
-	if !strings.HasPrefix(msg.Text, "/start") {
+func extractInviteCode(c telebot.Context) string {
+	if !isStartCommand(c) {
 		return ""
 	}
 
@@ -37,13 +44,16 @@ func extractInviteCode(c telebot.Context) string {
 	return args[0]
 }
 
+// Authenticate loads the settings and the dialog of the chat. In private chats they belong
+// to the user, and in group chats they are shared by all members of the group.
 func Authenticate(s store.Store) telebot.MiddlewareFunc {
 	return func(next telebot.HandlerFunc) telebot.HandlerFunc {
 		return func(c telebot.Context) error {
 			ctx := ybot.Ctx(c)
 			sender := c.Sender()
+			chat := c.Chat()
 
-			u, err := s.GetUser(ctx, sender.ID)
+			u, err := s.GetUser(ctx, chat.ID)
 			if err != nil {
 				return fmt.Errorf("GetUser: %w", err)
 			}
@@ -51,10 +61,14 @@ func Authenticate(s store.Store) telebot.MiddlewareFunc {
 			if u == nil {
 				u = &store.User{
 					Approved: false,
-					ChatId:   sender.ID,
+					ChatId:   chat.ID,
 					Username: sender.Username,
 					FullName: sender.FirstName + " " + sender.LastName,
 				}
+				if ybot.IsGroup(c) {
+					u.Username = chat.Username
+					u.FullName = chat.Title
+				}
 				newUser, err := s.PutUser(ctx, u)
 				if err != nil {
 					return fmt.Errorf("PutUser: %w", err)
@@ -69,6 +83,13 @@ func Authenticate(s store.Store) telebot.MiddlewareFunc {
 				return err
 			}
 
+			// The admin check takes a request to Telegram, so it is only made on /start.
+			if !u.Approved && ybot.IsGroup(c) && isStartCommand(c) {
+				if err := approveGroup(ctx, c, s, u); err != nil {
+					return err
+				}
+			}
+
 			if !u.Approved {
 				code := extractInviteCode(c)
 				if code == "" {
@@ -83,6 +104,13 @@ func Authenticate(s store.Store) telebot.MiddlewareFunc {
 				}
 			}
 
+			// Every forum topic is a separate conversation of the group.
+			if threadId := ybot.ThreadID(c); threadId != 0 {
+				if err := s.UseTopic(ctx, u, threadId); err != nil {
+					return err
+				}
+			}
+
 			c.Set(ctxKeyUser, u)
 
 			return next(c)
@@ -90,6 +118,99 @@ func Authenticate(s store.Store) telebot.MiddlewareFunc {
 	}
 }
 
+// approveGroup approves the group if the sender is both an approved user of the bot
+// and an administrator of the group. Otherwise, the group has to be approved with
+// an invite code like a user.
+func approveGroup(ctx context.Context, c telebot.Context, s store.Store, group *store.User) error {
+	u, err := s.GetUser(ctx, c.Sender().ID)
+	if err != nil {
+		return fmt.Errorf("GetUser: %w", err)
+	}
+	if u == nil || !u.Approved {
+		return nil
+	}
+
+	admin, err := ybot.IsAdmin(c.Bot(), c.Chat(), c.Sender())
+	if err != nil {
+		return fmt.Errorf("IsAdmin: %w", err)
+	}
+	if !admin {
+		return nil
+	}
+
+	if err := s.ApproveUser(ctx, group.ChatId); err != nil {
+		return fmt.Errorf("ApproveUser: %w", err)
+	}
+	group.Approved = true
+	return nil
+}
+
+// requireGroupAdmin returns ErrNotGroupAdmin if the sender is not an administrator
+// of the group chat. Private chats have no such restriction.
+func requireGroupAdmin(c telebot.Context) error {
+	if !ybot.IsGroup(c) {
+		return nil
+	}
+	admin, err := ybot.IsAdmin(c.Bot(), c.Chat(), c.Sender())
+	if err != nil {
+		return fmt.Errorf("IsAdmin: %w", err)
+	}
+	if !admin {
+		return ErrNotGroupAdmin
+	}
+	return nil
+}
+
+// CheckQuota rejects the update if the sender, the group chat, or the whole deployment
+// has exceeded the configured usage quota. The sender's quota covers all chats,
+// and the members of a group share the group quota on top of their own.
+func CheckQuota(s store.Store, cfg *Config) telebot.MiddlewareFunc {
+	return func(next telebot.HandlerFunc) telebot.HandlerFunc {
+		return func(c telebot.Context) error {
+			ctx := ybot.Ctx(c)
+			user, ok := c.Get(ctxKeyUser).(*store.User)
+			if !ok {
+				return ErrUserNotFound
+			}
+
+			now := time.Now()
+
+			err := cfg.Quotas.User.Check(now, cfg.Prices, func(since time.Time) ([]*store.UsageSummary, error) {
+				return s.GetSenderUsage(ctx, c.Sender().ID, since)
+			})
+			if err != nil {
+				return fmt.Errorf("user quota: %w", err)
+			}
+
+			if ybot.IsGroup(c) {
+				err := cfg.Quotas.Group.Check(now, cfg.Prices, func(since time.Time) ([]*store.UsageSummary, error) {
+					return s.GetUsage(ctx, user.ChatId, since)
+				})
+				if err != nil {
+					var qErr *QuotaError
+					if errors.As(err, &qErr) {
+						qErr.Group = true
+					}
+					return fmt.Errorf("group quota: %w", err)
+				}
+			}
+
+			err = cfg.Quotas.Global.Check(now, cfg.Prices, func(since time.Time) ([]*store.UsageSummary, error) {
+				return s.GetTotalUsage(ctx, since)
+			})
+			if err != nil {
+				var qErr *QuotaError
+				if errors.As(err, &qErr) {
+					qErr.Global = true
+				}
+				return fmt.Errorf("global quota: %w", err)
+			}
+
+			return next(c)
+		}
+	}
+}
+
 func ErrorHandler() telebot.MiddlewareFunc {
 	return func(next telebot.HandlerFunc) telebot.HandlerFunc {
 		return func(c telebot.Context) error {
@@ -100,9 +221,24 @@ func ErrorHandler() telebot.MiddlewareFunc {
 
 			loc := locale.New(ybot.Lang(c))
 
+			var qErr *QuotaError
+
 			switch {
+			case errors.As(err, &qErr):
+				reset := qErr.Reset.UTC().Format(quotaResetLayout)
+				if qErr.Global {
+					return c.Send(loc.ErrGlobalQuotaExceeded(reset))
+				}
+				if qErr.Group {
+					return c.Send(loc.ErrGroupQuotaExceeded(reset))
+				}
+				return c.Send(loc.ErrQuotaExceeded(reset))
 			case errors.Is(err, ErrNotApproved):
 				return c.Send(loc.ErrNotApproved())
+			case errors.Is(err, ErrNoModel):
+				return c.Send(loc.ModelNotAvailableMessage())
+			case errors.Is(err, ErrNotGroupAdmin):
+				return c.Send(loc.ErrNotGroupAdmin())
 			case errors.Is(err, ErrContextTooLong):
 				return c.Send(
 					loc.ErrContextTooLongMessage(),
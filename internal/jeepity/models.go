@@ -0,0 +1,124 @@
+package jeepity
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/exp/slices"
+)
+
+// ModelAccess restricts models to the listed users (chat IDs).
+// Models that are not in the map are available to everyone.
+type ModelAccess map[string][]int64
+
+// ParseModelAccess parses a comma-separated list of `model=chat_id;chat_id` entries.
+func ParseModelAccess(s string) (ModelAccess, error) {
+	access := ModelAccess{}
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		model, value, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid model access entry %q: expected model=chat_id;chat_id", entry)
+		}
+
+		var chatIds []int64
+		for _, v := range strings.Split(value, ";") {
+			if v = strings.TrimSpace(v); v == "" {
+				continue
+			}
+			chatId, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid chat id in %q: %w", entry, err)
+			}
+			chatIds = append(chatIds, chatId)
+		}
+
+		model = strings.TrimSpace(model)
+		access[model] = append(access[model], chatIds...)
+	}
+
+	return access, nil
+}
+
+// ModelFallbacks maps models to the ordered list of their fallback models.
+type ModelFallbacks map[string][]string
+
+// ParseModelFallbacks parses a comma-separated list of `model=fallback;fallback` entries.
+func ParseModelFallbacks(s string) (ModelFallbacks, error) {
+	fallbacks := ModelFallbacks{}
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		model, value, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid fallback entry %q: expected model=fallback;fallback", entry)
+		}
+
+		model = strings.TrimSpace(model)
+		for _, v := range strings.Split(value, ";") {
+			if v = strings.TrimSpace(v); v != "" {
+				fallbacks[model] = append(fallbacks[model], v)
+			}
+		}
+	}
+
+	return fallbacks, nil
+}
+
+// ModelChain returns the model followed by its fallbacks that are available to the user.
+// The fallbacks do not have to be listed for /model, but the access restrictions still apply.
+func (c *Config) ModelChain(chatId int64, model string) []string {
+	chain := []string{model}
+	for _, m := range c.Fallbacks[model] {
+		if !slices.Contains(chain, m) && c.modelPermitted(chatId, m) {
+			chain = append(chain, m)
+		}
+	}
+	return chain
+}
+
+// PromptBudget returns the maximum number of prompt tokens for the model.
+func (c *Config) PromptBudget(model string) int {
+	return c.ContextWindows.Lookup(model) - c.ReplyTokens
+}
+
+// ChatModels returns the default chat model followed by the other configured models.
+func (c *Config) ChatModels() []string {
+	models := []string{c.ChatModel}
+	for _, m := range c.Models {
+		if !slices.Contains(models, m) {
+			models = append(models, m)
+		}
+	}
+	return models
+}
+
+// ModelAllowed checks whether the model is configured and available to the user.
+func (c *Config) ModelAllowed(chatId int64, model string) bool {
+	return slices.Contains(c.ChatModels(), model) && c.modelPermitted(chatId, model)
+}
+
+// modelPermitted checks whether the model is not restricted to other users.
+func (c *Config) modelPermitted(chatId int64, model string) bool {
+	chatIds, restricted := c.ModelAccess[model]
+	return !restricted || slices.Contains(chatIds, chatId)
+}
+
+// UserModels returns the chat models available to the user.
+func (c *Config) UserModels(chatId int64) []string {
+	var models []string
+	for _, m := range c.ChatModels() {
+		if c.ModelAllowed(chatId, m) {
+			models = append(models, m)
+		}
+	}
+	return models
+}
@@ -0,0 +1,107 @@
+package jeepity
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"mkuznets.com/go/jeepity/internal/store"
+)
+
+// ErrProviderUnavailable is returned when the backend is overloaded, rate-limited,
+// or cannot be reached, so the request may succeed with another model.
+var ErrProviderUnavailable = errors.New("provider unavailable")
+
+// ChatRequest is a provider-agnostic chat completion request.
+type ChatRequest struct {
+	Model    string
+	Messages []*store.Message
+	// User is an opaque end-user identifier passed to the provider.
+	User string
+}
+
+// ChatProvider is a backend that generates chat completions.
+type ChatProvider interface {
+	// ChatStream generates the completion of the dialog and calls onDelta with
+	// every chunk of the response as it arrives.
+	//
+	// The returned Completion contains the full response and the token usage,
+	// either as reported by the backend or estimated locally.
+	// ErrContextTooLong is returned if the dialog does not fit into the model's context.
+	// ErrProviderUnavailable is returned on server errors, rate limits, and network failures.
+	ChatStream(ctx context.Context, req *ChatRequest, onDelta func(delta string)) (*Completion, error)
+}
+
+// Transcription is the result of an audio transcription.
+type Transcription struct {
+	Model string
+	Text  string
+	// Duration is the length of the audio, which the transcription is billed by.
+	Duration time.Duration
+}
+
+// Transcriber is a backend that converts speech into text.
+type Transcriber interface {
+	// Transcribe returns the text of the audio file.
+	Transcribe(ctx context.Context, model, filePath string) (*Transcription, error)
+}
+
+// isUnavailableStatus checks whether the HTTP status means that the backend
+// is temporarily unable to serve the request.
+func isUnavailableStatus(code int) bool {
+	return code == http.StatusTooManyRequests || code >= http.StatusInternalServerError
+}
+
+// providerTimeout limits the whole chat request, including the streamed response,
+// so that a stalled backend does not block the dialog forever.
+const providerTimeout = 10 * time.Minute
+
+// newProviderClient returns the HTTP client for the chat backends.
+// Timeouts are network errors, so they are classified as ErrProviderUnavailable.
+func newProviderClient() *http.Client {
+	return &http.Client{Timeout: providerTimeout}
+}
+
+// classifyNetworkError wraps network failures into ErrProviderUnavailable.
+func classifyNetworkError(err error) error {
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return fmt.Errorf("%w: %v", ErrProviderUnavailable, err)
+	}
+	return err
+}
+
+// ChatRouter dispatches chat requests to providers by the model name.
+type ChatRouter struct {
+	fallback  ChatProvider
+	providers map[string]ChatProvider
+}
+
+// NewChatRouter creates a router that sends requests for unregistered models to the fallback provider.
+// If the fallback is nil, only the registered models are available.
+func NewChatRouter(fallback ChatProvider) *ChatRouter {
+	return &ChatRouter{
+		fallback:  fallback,
+		providers: make(map[string]ChatProvider),
+	}
+}
+
+// Register routes requests for the models to the provider.
+func (r *ChatRouter) Register(p ChatProvider, models ...string) {
+	for _, m := range models {
+		r.providers[m] = p
+	}
+}
+
+func (r *ChatRouter) ChatStream(ctx context.Context, req *ChatRequest, onDelta func(delta string)) (*Completion, error) {
+	if p, ok := r.providers[req.Model]; ok {
+		return p.ChatStream(ctx, req, onDelta)
+	}
+	if r.fallback == nil {
+		return nil, fmt.Errorf("no provider is configured for model %s", req.Model)
+	}
+	return r.fallback.ChatStream(ctx, req, onDelta)
+}
@@ -0,0 +1,265 @@
+package jeepity
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"mkuznets.com/go/jeepity/internal/store"
+)
+
+const (
+	anthropicMessagesPath = "/v1/messages"
+	anthropicVersion      = "2023-06-01"
+	// Maximum length of a single SSE line in the response stream.
+	anthropicMaxLineSize = 1024 * 1024
+	// Maximum length of an error response body.
+	anthropicMaxErrorSize = 4096
+	// Error type returned when the API is temporarily overloaded.
+	anthropicOverloadedError = "overloaded_error"
+)
+
+var anthropicDataPrefix = []byte("data:")
+
+// AnthropicError is returned when the Anthropic API responds with an error.
+type AnthropicError struct {
+	StatusCode int
+	Type       string
+	Message    string
+}
+
+func (e *AnthropicError) Error() string {
+	return fmt.Sprintf("anthropic: %s: %s (status %d)", e.Type, e.Message, e.StatusCode)
+}
+
+type anthropicMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type anthropicRequest struct {
+	Model     string             `json:"model"`
+	System    string             `json:"system,omitempty"`
+	Messages  []anthropicMessage `json:"messages"`
+	MaxTokens int                `json:"max_tokens"`
+	Stream    bool               `json:"stream"`
+	Metadata  *anthropicMetadata `json:"metadata,omitempty"`
+}
+
+type anthropicMetadata struct {
+	UserId string `json:"user_id,omitempty"`
+}
+
+type anthropicUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+type anthropicErrorBody struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+// anthropicEvent is the union of the streaming event payloads.
+type anthropicEvent struct {
+	Type    string `json:"type"`
+	Message struct {
+		Model string         `json:"model"`
+		Usage anthropicUsage `json:"usage"`
+	} `json:"message"`
+	Delta struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	} `json:"delta"`
+	Usage *anthropicUsage     `json:"usage"`
+	Error *anthropicErrorBody `json:"error"`
+}
+
+// AnthropicProvider implements ChatProvider using the Anthropic Messages API.
+type AnthropicProvider struct {
+	baseUrl   string
+	token     string
+	maxTokens int
+	client    *http.Client
+}
+
+func NewAnthropicProvider(baseUrl, token string, maxTokens int) *AnthropicProvider {
+	return &AnthropicProvider{
+		baseUrl:   strings.TrimRight(baseUrl, "/"),
+		token:     token,
+		maxTokens: maxTokens,
+		client:    newProviderClient(),
+	}
+}
+
+func (p *AnthropicProvider) ChatStream(ctx context.Context, req *ChatRequest, onDelta func(delta string)) (*Completion, error) {
+	system, messages := messagesToAnthropicMessages(req.Messages)
+
+	aReq := &anthropicRequest{
+		Model:     req.Model,
+		System:    system,
+		Messages:  messages,
+		MaxTokens: p.maxTokens,
+		Stream:    true,
+	}
+	if req.User != "" {
+		aReq.Metadata = &anthropicMetadata{UserId: req.User}
+	}
+
+	body, err := json.Marshal(aReq)
+	if err != nil {
+		return nil, fmt.Errorf("marshal request: %w", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, p.baseUrl+anthropicMessagesPath, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("new request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Accept", "text/event-stream")
+	httpReq.Header.Set("X-Api-Key", p.token)
+	httpReq.Header.Set("Anthropic-Version", anthropicVersion)
+
+	resp, err := p.client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("anthropic request: %w", classifyNetworkError(err))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, classifyAnthropicError(anthropicResponseError(resp))
+	}
+
+	completion := &Completion{Model: req.Model}
+	var buf strings.Builder
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, bufio.MaxScanTokenSize), anthropicMaxLineSize)
+
+	for scanner.Scan() {
+		// Event types are duplicated in the data payloads, so only data lines are parsed.
+		line := scanner.Bytes()
+		if !bytes.HasPrefix(line, anthropicDataPrefix) {
+			continue
+		}
+
+		var event anthropicEvent
+		if err := json.Unmarshal(bytes.TrimSpace(line[len(anthropicDataPrefix):]), &event); err != nil {
+			return nil, fmt.Errorf("unmarshal event: %w", err)
+		}
+
+		switch event.Type {
+		case "message_start":
+			if event.Message.Model != "" {
+				completion.Model = event.Message.Model
+			}
+			completion.PromptTokens = event.Message.Usage.InputTokens
+			completion.CompletionTokens = event.Message.Usage.OutputTokens
+		case "content_block_delta":
+			if event.Delta.Type == "text_delta" && event.Delta.Text != "" {
+				buf.WriteString(event.Delta.Text)
+				onDelta(event.Delta.Text)
+			}
+		case "message_delta":
+			// The output token count in message_delta is cumulative.
+			if event.Usage != nil {
+				completion.CompletionTokens = event.Usage.OutputTokens
+			}
+		case "error":
+			aErr := &AnthropicError{StatusCode: resp.StatusCode}
+			if event.Error != nil {
+				aErr.Type, aErr.Message = event.Error.Type, event.Error.Message
+			}
+			return nil, classifyAnthropicError(aErr)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read response: %w", classifyNetworkError(err))
+	}
+
+	completion.Response = buf.String()
+
+	if completion.PromptTokens == 0 {
+		completion.PromptTokens = CountMessagesTokens(req.Model, req.Messages)
+	}
+	if completion.CompletionTokens == 0 {
+		completion.CompletionTokens = CountTokens(req.Model, completion.Response)
+	}
+	completion.TotalTokens = completion.PromptTokens + completion.CompletionTokens
+
+	return completion, nil
+}
+
+func anthropicResponseError(resp *http.Response) *AnthropicError {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, anthropicMaxErrorSize))
+
+	aErr := &AnthropicError{
+		StatusCode: resp.StatusCode,
+		Type:       "http_error",
+		Message:    strings.TrimSpace(string(body)),
+	}
+
+	var errResp struct {
+		Error *anthropicErrorBody `json:"error"`
+	}
+	if err := json.Unmarshal(body, &errResp); err == nil && errResp.Error != nil {
+		aErr.Type, aErr.Message = errResp.Error.Type, errResp.Error.Message
+	}
+	if aErr.Message == "" {
+		aErr.Message = http.StatusText(resp.StatusCode)
+	}
+
+	return aErr
+}
+
+// classifyAnthropicError wraps the API errors into the provider-agnostic ones.
+func classifyAnthropicError(err *AnthropicError) error {
+	switch {
+	case strings.Contains(err.Message, "prompt is too long"):
+		return fmt.Errorf("%w: %v", ErrContextTooLong, err)
+	case err.Type == anthropicOverloadedError || isUnavailableStatus(err.StatusCode):
+		return fmt.Errorf("%w: %v", ErrProviderUnavailable, err)
+	}
+	return err
+}
+
+// messagesToAnthropicMessages extracts the system prompt and converts the rest
+// of the dialog into alternating user and assistant messages starting with a user one.
+func messagesToAnthropicMessages(messages []*store.Message) (string, []anthropicMessage) {
+	var (
+		system []string
+		res    []anthropicMessage
+	)
+
+	for _, m := range messages {
+		role := m.Role
+		switch role {
+		case store.RoleSystem:
+			system = append(system, m.Message)
+			continue
+		case store.RoleAssistant:
+		default:
+			role = store.RoleUser
+		}
+
+		// The API requires the first message to be a user one. The assistant messages
+		// may come first when the question they answer has been trimmed, so they are dropped.
+		if len(res) == 0 && role == store.RoleAssistant {
+			continue
+		}
+		// The API requires the roles to alternate, so consecutive
+		// messages of the same role are merged.
+		if n := len(res); n > 0 && res[n-1].Role == role {
+			res[n-1].Content += "\n\n" + m.Message
+			continue
+		}
+		res = append(res, anthropicMessage{Role: role, Content: m.Message})
+	}
+
+	return strings.Join(system, "\n\n"), res
+}
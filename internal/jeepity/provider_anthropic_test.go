@@ -0,0 +1,284 @@
+package jeepity
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"mkuznets.com/go/jeepity/internal/store"
+)
+
+// anthropicStub serves the canned response to the message requests and records the last request.
+type anthropicStub struct {
+	status int
+	body   string
+	req    anthropicRequest
+	header http.Header
+}
+
+func (s *anthropicStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.URL.Path != anthropicMessagesPath {
+		http.NotFound(w, r)
+		return
+	}
+	s.header = r.Header.Clone()
+	if err := json.NewDecoder(r.Body).Decode(&s.req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.WriteHeader(s.status)
+	_, _ = w.Write([]byte(s.body))
+}
+
+// sse formats the events as a server-sent event stream.
+func sse(events ...string) string {
+	var b strings.Builder
+	for _, e := range events {
+		var event struct {
+			Type string `json:"type"`
+		}
+		_ = json.Unmarshal([]byte(e), &event)
+		b.WriteString("event: " + event.Type + "\ndata: " + e + "\n\n")
+	}
+	return b.String()
+}
+
+func TestAnthropicProviderChatStream(t *testing.T) {
+	messages := []*store.Message{
+		{Role: store.RoleSystem, Message: "Be brief."},
+		{Role: store.RoleUser, Message: "Hello"},
+		{Role: store.RoleAssistant, Message: "Hi!"},
+		{Role: store.RoleUser, Message: "How are you?"},
+	}
+
+	tests := []struct {
+		name   string
+		status int
+		body   string
+
+		wantResponse    string
+		wantDeltas      []string
+		wantModel       string
+		wantPrompt      int
+		wantCompletion  int
+		wantUnavailable bool
+		wantTooLong     bool
+		wantErr         bool
+		wantErrText     string
+	}{
+		{
+			name:   "streaming with usage",
+			status: http.StatusOK,
+			body: sse(
+				`{"type":"message_start","message":{"model":"claude-3-haiku-20240307","usage":{"input_tokens":25,"output_tokens":1}}}`,
+				`{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+				`{"type":"ping"}`,
+				`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Fine"}}`,
+				`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":", thanks!"}}`,
+				`{"type":"content_block_stop","index":0}`,
+				`{"type":"message_delta","delta":{"stop_reason":"end_turn"},"usage":{"output_tokens":5}}`,
+				`{"type":"message_stop"}`,
+			),
+			wantResponse:   "Fine, thanks!",
+			wantDeltas:     []string{"Fine", ", thanks!"},
+			wantModel:      "claude-3-haiku-20240307",
+			wantPrompt:     25,
+			wantCompletion: 5,
+		},
+		{
+			name:   "usage is estimated when missing",
+			status: http.StatusOK,
+			body: sse(
+				`{"type":"message_start","message":{"model":"","usage":{"input_tokens":0,"output_tokens":0}}}`,
+				`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hello, world!"}}`,
+				`{"type":"message_stop"}`,
+			),
+			wantResponse:   "Hello, world!",
+			wantDeltas:     []string{"Hello, world!"},
+			wantModel:      "claude-3-haiku",
+			wantPrompt:     CountMessagesTokens("claude-3-haiku", messages),
+			wantCompletion: CountTokens("claude-3-haiku", "Hello, world!"),
+		},
+		{
+			name:   "overloaded error in the stream",
+			status: http.StatusOK,
+			body: sse(
+				`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Fine"}}`,
+				`{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`,
+			),
+			wantDeltas:      []string{"Fine"},
+			wantUnavailable: true,
+			wantErr:         true,
+			wantErrText:     "Overloaded",
+		},
+		{
+			name:            "server error",
+			status:          http.StatusInternalServerError,
+			body:            `{"type":"error","error":{"type":"api_error","message":"Internal server error"}}`,
+			wantUnavailable: true,
+			wantErr:         true,
+			wantErrText:     "Internal server error",
+		},
+		{
+			name:        "prompt too long",
+			status:      http.StatusBadRequest,
+			body:        `{"type":"error","error":{"type":"invalid_request_error","message":"prompt is too long: 210000 tokens > 200000 maximum"}}`,
+			wantTooLong: true,
+			wantErr:     true,
+			wantErrText: "prompt is too long",
+		},
+		{
+			name:        "invalid request",
+			status:      http.StatusBadRequest,
+			body:        `{"type":"error","error":{"type":"invalid_request_error","message":"max_tokens: field required"}}`,
+			wantErr:     true,
+			wantErrText: "max_tokens: field required",
+		},
+		{
+			name:        "malformed event",
+			status:      http.StatusOK,
+			body:        "event: message_start\ndata: {\"type\":\n\n",
+			wantErr:     true,
+			wantErrText: "unmarshal event",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := &anthropicStub{status: tt.status, body: tt.body}
+			srv := httptest.NewServer(stub)
+			defer srv.Close()
+
+			var deltas []string
+			p := NewAnthropicProvider(srv.URL+"/", "secret", 1024)
+			req := &ChatRequest{Model: "claude-3-haiku", Messages: messages, User: "user-1"}
+			completion, err := p.ChatStream(context.Background(), req, func(delta string) {
+				deltas = append(deltas, delta)
+			})
+
+			if stub.header.Get("X-Api-Key") != "secret" || stub.header.Get("Anthropic-Version") != anthropicVersion {
+				t.Errorf("headers = %v, want the API key and version", stub.header)
+			}
+			if stub.req.Model != "claude-3-haiku" || !stub.req.Stream || stub.req.MaxTokens != 1024 {
+				t.Errorf("request = %+v, want streaming claude-3-haiku with 1024 max tokens", stub.req)
+			}
+			if stub.req.System != "Be brief." || len(stub.req.Messages) != 3 {
+				t.Errorf("request system = %q, messages = %+v, want the system prompt at the top level", stub.req.System, stub.req.Messages)
+			}
+			if stub.req.Metadata == nil || stub.req.Metadata.UserId != "user-1" {
+				t.Errorf("request metadata = %+v, want the user ID", stub.req.Metadata)
+			}
+			if strings.Join(deltas, "|") != strings.Join(tt.wantDeltas, "|") {
+				t.Errorf("deltas = %q, want %q", deltas, tt.wantDeltas)
+			}
+
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("err = nil, want an error")
+				}
+				if got := errors.Is(err, ErrProviderUnavailable); got != tt.wantUnavailable {
+					t.Errorf("errors.Is(%v, ErrProviderUnavailable) = %v, want %v", err, got, tt.wantUnavailable)
+				}
+				if got := errors.Is(err, ErrContextTooLong); got != tt.wantTooLong {
+					t.Errorf("errors.Is(%v, ErrContextTooLong) = %v, want %v", err, got, tt.wantTooLong)
+				}
+				if !strings.Contains(err.Error(), tt.wantErrText) {
+					t.Errorf("err = %v, want it to contain %q", err, tt.wantErrText)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("err = %v", err)
+			}
+
+			if completion.Response != tt.wantResponse {
+				t.Errorf("Response = %q, want %q", completion.Response, tt.wantResponse)
+			}
+			if completion.Model != tt.wantModel {
+				t.Errorf("Model = %q, want %q", completion.Model, tt.wantModel)
+			}
+			if completion.PromptTokens != tt.wantPrompt || completion.CompletionTokens != tt.wantCompletion {
+				t.Errorf("tokens = %d/%d, want %d/%d", completion.PromptTokens, completion.CompletionTokens, tt.wantPrompt, tt.wantCompletion)
+			}
+			if completion.TotalTokens != tt.wantPrompt+tt.wantCompletion {
+				t.Errorf("TotalTokens = %d, want %d", completion.TotalTokens, tt.wantPrompt+tt.wantCompletion)
+			}
+		})
+	}
+}
+
+func TestMessagesToAnthropicMessages(t *testing.T) {
+	tests := []struct {
+		name       string
+		messages   []*store.Message
+		wantSystem string
+		want       []anthropicMessage
+	}{
+		{
+			name: "system messages are moved to the top level",
+			messages: []*store.Message{
+				{Role: store.RoleSystem, Message: "Be brief."},
+				{Role: store.RoleUser, Message: "Hello"},
+				{Role: store.RoleSystem, Message: "Answer in English."},
+			},
+			wantSystem: "Be brief.\n\nAnswer in English.",
+			want:       []anthropicMessage{{Role: store.RoleUser, Content: "Hello"}},
+		},
+		{
+			name: "consecutive messages of the same role are merged",
+			messages: []*store.Message{
+				{Role: store.RoleSummary, Message: "Earlier, the user said hi."},
+				{Role: store.RoleUser, Message: "Hello"},
+				{Role: store.RoleAssistant, Message: "Hi!"},
+				{Role: store.RoleAssistant, Message: "How can I help?"},
+				{Role: store.RoleUser, Message: "Tell a joke"},
+			},
+			want: []anthropicMessage{
+				{Role: store.RoleUser, Content: "Earlier, the user said hi.\n\nHello"},
+				{Role: store.RoleAssistant, Content: "Hi!\n\nHow can I help?"},
+				{Role: store.RoleUser, Content: "Tell a joke"},
+			},
+		},
+		{
+			name: "leading assistant messages are dropped",
+			messages: []*store.Message{
+				{Role: store.RoleSystem, Message: "Be brief."},
+				{Role: store.RoleAssistant, Message: "The answer to the trimmed question."},
+				{Role: store.RoleUser, Message: "Thanks"},
+			},
+			wantSystem: "Be brief.",
+			want:       []anthropicMessage{{Role: store.RoleUser, Content: "Thanks"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			system, got := messagesToAnthropicMessages(tt.messages)
+			if system != tt.wantSystem {
+				t.Errorf("system = %q, want %q", system, tt.wantSystem)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("messages = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAnthropicProviderUnreachable(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	url := srv.URL
+	srv.Close()
+
+	p := NewAnthropicProvider(url, "secret", 1024)
+	_, err := p.ChatStream(context.Background(), &ChatRequest{Model: "claude-3-haiku"}, func(string) {})
+	if !errors.Is(err, ErrProviderUnavailable) {
+		t.Errorf("err = %v, want ErrProviderUnavailable", err)
+	}
+}
@@ -0,0 +1,183 @@
+package jeepity
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"mkuznets.com/go/jeepity/internal/store"
+)
+
+const (
+	ollamaChatPath = "/api/chat"
+	// Maximum length of a single NDJSON line in the response stream.
+	ollamaMaxLineSize = 1024 * 1024
+	// Maximum length of an error response body.
+	ollamaMaxErrorSize = 4096
+)
+
+// OllamaError is returned when the Ollama server responds with an error.
+type OllamaError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *OllamaError) Error() string {
+	return fmt.Sprintf("ollama: %s (status %d)", e.Message, e.StatusCode)
+}
+
+type ollamaMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type ollamaChatRequest struct {
+	Model    string          `json:"model"`
+	Messages []ollamaMessage `json:"messages"`
+	Stream   bool            `json:"stream"`
+}
+
+type ollamaChatResponse struct {
+	Model           string        `json:"model"`
+	Message         ollamaMessage `json:"message"`
+	Done            bool          `json:"done"`
+	PromptEvalCount int           `json:"prompt_eval_count"`
+	EvalCount       int           `json:"eval_count"`
+	Error           string        `json:"error"`
+}
+
+// OllamaProvider implements ChatProvider using the native Ollama API.
+type OllamaProvider struct {
+	baseUrl string
+	client  *http.Client
+}
+
+func NewOllamaProvider(baseUrl string) *OllamaProvider {
+	return &OllamaProvider{
+		baseUrl: strings.TrimRight(baseUrl, "/"),
+		client:  newProviderClient(),
+	}
+}
+
+func (p *OllamaProvider) ChatStream(ctx context.Context, req *ChatRequest, onDelta func(delta string)) (*Completion, error) {
+	body, err := json.Marshal(&ollamaChatRequest{
+		Model:    req.Model,
+		Messages: messagesToOllamaMessages(req.Messages),
+		Stream:   true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("marshal request: %w", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, p.baseUrl+ollamaChatPath, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("new request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("ollama request: %w", classifyNetworkError(err))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, ollamaResponseError(resp)
+	}
+
+	completion := &Completion{Model: req.Model}
+	var buf strings.Builder
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, bufio.MaxScanTokenSize), ollamaMaxLineSize)
+
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		var chunk ollamaChatResponse
+		if err := json.Unmarshal(line, &chunk); err != nil {
+			return nil, fmt.Errorf("unmarshal response: %w", err)
+		}
+		if chunk.Error != "" {
+			// The errors in the middle of the stream are the failures of the model runner,
+			// like the server errors before it starts.
+			oErr := &OllamaError{StatusCode: resp.StatusCode, Message: chunk.Error}
+			return nil, fmt.Errorf("%w: %v", ErrProviderUnavailable, oErr)
+		}
+
+		if chunk.Model != "" {
+			completion.Model = chunk.Model
+		}
+		if chunk.Message.Content != "" {
+			buf.WriteString(chunk.Message.Content)
+			onDelta(chunk.Message.Content)
+		}
+		if chunk.Done {
+			completion.PromptTokens = chunk.PromptEvalCount
+			completion.CompletionTokens = chunk.EvalCount
+			break
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read response: %w", classifyNetworkError(err))
+	}
+
+	completion.Response = buf.String()
+
+	// Ollama omits the counters when the prompt is served from cache.
+	if completion.PromptTokens == 0 {
+		completion.PromptTokens = CountMessagesTokens(req.Model, req.Messages)
+	}
+	if completion.CompletionTokens == 0 {
+		completion.CompletionTokens = CountTokens(req.Model, completion.Response)
+	}
+	completion.TotalTokens = completion.PromptTokens + completion.CompletionTokens
+
+	return completion, nil
+}
+
+func ollamaResponseError(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, ollamaMaxErrorSize))
+
+	var errResp struct {
+		Error string `json:"error"`
+	}
+	message := strings.TrimSpace(string(body))
+	if err := json.Unmarshal(body, &errResp); err == nil && errResp.Error != "" {
+		message = errResp.Error
+	}
+	if message == "" {
+		message = http.StatusText(resp.StatusCode)
+	}
+
+	oErr := &OllamaError{StatusCode: resp.StatusCode, Message: message}
+	if isUnavailableStatus(resp.StatusCode) {
+		return fmt.Errorf("%w: %v", ErrProviderUnavailable, oErr)
+	}
+	return oErr
+}
+
+func messagesToOllamaMessages(messages []*store.Message) []ollamaMessage {
+	res := make([]ollamaMessage, len(messages))
+	for i, m := range messages {
+		role := m.Role
+		switch role {
+		case store.RoleSystem, store.RoleUser, store.RoleAssistant:
+		default:
+			role = store.RoleUser
+		}
+		res[i] = ollamaMessage{
+			Role:    role,
+			Content: m.Message,
+		}
+	}
+	return res
+}
@@ -0,0 +1,208 @@
+package jeepity
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"mkuznets.com/go/jeepity/internal/store"
+)
+
+// ollamaStub serves the canned response to the chat requests and records the last request.
+type ollamaStub struct {
+	status int
+	body   string
+	req    ollamaChatRequest
+}
+
+func (s *ollamaStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.URL.Path != ollamaChatPath {
+		http.NotFound(w, r)
+		return
+	}
+	if err := json.NewDecoder(r.Body).Decode(&s.req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(s.status)
+	_, _ = w.Write([]byte(s.body))
+}
+
+func ndjson(lines ...string) string {
+	return strings.Join(lines, "\n") + "\n"
+}
+
+func TestOllamaProviderChatStream(t *testing.T) {
+	messages := []*store.Message{
+		{Role: store.RoleSystem, Message: "Be brief."},
+		{Role: store.RoleUser, Message: "Hello"},
+		{Role: store.RoleSummary, Message: "Earlier, the user said hi."},
+	}
+
+	tests := []struct {
+		name   string
+		status int
+		body   string
+
+		wantResponse    string
+		wantDeltas      []string
+		wantModel       string
+		wantPrompt      int
+		wantCompletion  int
+		wantUnavailable bool
+		wantErr         bool
+		wantErrText     string
+	}{
+		{
+			name:   "streaming with usage",
+			status: http.StatusOK,
+			body: ndjson(
+				`{"model":"llama2:7b","message":{"role":"assistant","content":"Hi"},"done":false}`,
+				``,
+				`{"model":"llama2:7b","message":{"role":"assistant","content":" there!"},"done":false}`,
+				`{"model":"llama2:7b","message":{"role":"assistant","content":""},"done":true,"prompt_eval_count":26,"eval_count":3}`,
+			),
+			wantResponse:   "Hi there!",
+			wantDeltas:     []string{"Hi", " there!"},
+			wantModel:      "llama2:7b",
+			wantPrompt:     26,
+			wantCompletion: 3,
+		},
+		{
+			name:   "lines after done are ignored",
+			status: http.StatusOK,
+			body: ndjson(
+				`{"model":"llama2","message":{"role":"assistant","content":"Yes"},"done":true,"prompt_eval_count":5,"eval_count":1}`,
+				`{"model":"llama2","message":{"role":"assistant","content":"garbage"},"done":false}`,
+			),
+			wantResponse:   "Yes",
+			wantDeltas:     []string{"Yes"},
+			wantModel:      "llama2",
+			wantPrompt:     5,
+			wantCompletion: 1,
+		},
+		{
+			name:   "usage is estimated when missing",
+			status: http.StatusOK,
+			body: ndjson(
+				`{"model":"llama2","message":{"role":"assistant","content":"Hello, world!"},"done":false}`,
+				`{"model":"llama2","done":true}`,
+			),
+			wantResponse:   "Hello, world!",
+			wantDeltas:     []string{"Hello, world!"},
+			wantModel:      "llama2",
+			wantPrompt:     CountMessagesTokens("llama2", messages),
+			wantCompletion: CountTokens("llama2", "Hello, world!"),
+		},
+		{
+			name:            "error in the stream",
+			status:          http.StatusOK,
+			body:            ndjson(`{"model":"llama2","message":{"role":"assistant","content":"Hi"},"done":false}`, `{"error":"llama runner process has terminated"}`),
+			wantDeltas:      []string{"Hi"},
+			wantUnavailable: true,
+			wantErr:         true,
+			wantErrText:     "llama runner process has terminated",
+		},
+		{
+			name:            "server error",
+			status:          http.StatusServiceUnavailable,
+			body:            `{"error":"server busy"}`,
+			wantUnavailable: true,
+			wantErr:         true,
+			wantErrText:     "server busy",
+		},
+		{
+			name:            "rate limit",
+			status:          http.StatusTooManyRequests,
+			body:            `too many requests`,
+			wantUnavailable: true,
+			wantErr:         true,
+			wantErrText:     "too many requests",
+		},
+		{
+			name:        "unknown model",
+			status:      http.StatusNotFound,
+			body:        `{"error":"model 'llama3' not found, try pulling it first"}`,
+			wantErr:     true,
+			wantErrText: "model 'llama3' not found",
+		},
+		{
+			name:        "malformed line",
+			status:      http.StatusOK,
+			body:        ndjson(`{"model":`),
+			wantErr:     true,
+			wantErrText: "unmarshal response",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := &ollamaStub{status: tt.status, body: tt.body}
+			srv := httptest.NewServer(stub)
+			defer srv.Close()
+
+			var deltas []string
+			p := NewOllamaProvider(srv.URL + "/")
+			completion, err := p.ChatStream(context.Background(), &ChatRequest{Model: "llama2", Messages: messages}, func(delta string) {
+				deltas = append(deltas, delta)
+			})
+
+			if stub.req.Model != "llama2" || !stub.req.Stream {
+				t.Errorf("request = %+v, want streaming llama2", stub.req)
+			}
+			if len(stub.req.Messages) != len(messages) || stub.req.Messages[2].Role != store.RoleUser {
+				t.Errorf("request messages = %+v, want the summary sent as a user message", stub.req.Messages)
+			}
+			if strings.Join(deltas, "|") != strings.Join(tt.wantDeltas, "|") {
+				t.Errorf("deltas = %q, want %q", deltas, tt.wantDeltas)
+			}
+
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("err = nil, want an error")
+				}
+				if got := errors.Is(err, ErrProviderUnavailable); got != tt.wantUnavailable {
+					t.Errorf("errors.Is(%v, ErrProviderUnavailable) = %v, want %v", err, got, tt.wantUnavailable)
+				}
+				if !strings.Contains(err.Error(), tt.wantErrText) {
+					t.Errorf("err = %v, want it to contain %q", err, tt.wantErrText)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("err = %v", err)
+			}
+
+			if completion.Response != tt.wantResponse {
+				t.Errorf("Response = %q, want %q", completion.Response, tt.wantResponse)
+			}
+			if completion.Model != tt.wantModel {
+				t.Errorf("Model = %q, want %q", completion.Model, tt.wantModel)
+			}
+			if completion.PromptTokens != tt.wantPrompt || completion.CompletionTokens != tt.wantCompletion {
+				t.Errorf("tokens = %d/%d, want %d/%d", completion.PromptTokens, completion.CompletionTokens, tt.wantPrompt, tt.wantCompletion)
+			}
+			if completion.TotalTokens != tt.wantPrompt+tt.wantCompletion {
+				t.Errorf("TotalTokens = %d, want %d", completion.TotalTokens, tt.wantPrompt+tt.wantCompletion)
+			}
+		})
+	}
+}
+
+func TestOllamaProviderUnreachable(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	url := srv.URL
+	srv.Close()
+
+	p := NewOllamaProvider(url)
+	_, err := p.ChatStream(context.Background(), &ChatRequest{Model: "llama2"}, func(string) {})
+	if !errors.Is(err, ErrProviderUnavailable) {
+		t.Errorf("err = %v, want ErrProviderUnavailable", err)
+	}
+}
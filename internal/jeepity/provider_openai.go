@@ -0,0 +1,119 @@
+package jeepity
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+
+	"mkuznets.com/go/jeepity/internal/store"
+)
+
+const openAiContextLengthExceeded = "context_length_exceeded"
+
+// OpenAiProvider implements ChatProvider and Transcriber using the OpenAI API.
+type OpenAiProvider struct {
+	client *openai.Client
+}
+
+func NewOpenAiProvider(client *openai.Client) *OpenAiProvider {
+	return &OpenAiProvider{client: client}
+}
+
+func (p *OpenAiProvider) ChatStream(ctx context.Context, req *ChatRequest, onDelta func(delta string)) (*Completion, error) {
+	oReq := openai.ChatCompletionRequest{
+		Model:    req.Model,
+		User:     req.User,
+		Messages: messagesToOpenAiMessages(req.Messages),
+	}
+
+	stream, err := p.client.CreateChatCompletionStream(ctx, oReq)
+	if err != nil {
+		return nil, fmt.Errorf("CreateChatCompletionStream: %w", classifyOpenAiError(err))
+	}
+	defer stream.Close()
+
+	completion := &Completion{Model: req.Model}
+	var buf strings.Builder
+
+	for {
+		response, err := stream.Recv()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, fmt.Errorf("stream.Recv: %w", classifyOpenAiError(err))
+		}
+
+		if response.Model != "" {
+			completion.Model = response.Model
+		}
+		if len(response.Choices) > 0 {
+			delta := response.Choices[0].Delta.Content
+			buf.WriteString(delta)
+			onDelta(delta)
+		}
+	}
+
+	// Streaming responses do not report usage, so the tokens are counted with the encoding of the model.
+	completion.Response = buf.String()
+	completion.PromptTokens = CountMessagesTokens(req.Model, req.Messages)
+	completion.CompletionTokens = CountTokens(req.Model, completion.Response)
+	completion.TotalTokens = completion.PromptTokens + completion.CompletionTokens
+
+	return completion, nil
+}
+
+func (p *OpenAiProvider) Transcribe(ctx context.Context, model, filePath string) (*Transcription, error) {
+	// The verbose response also contains the duration of the audio.
+	resp, err := p.client.CreateTranscription(ctx, openai.AudioRequest{
+		Model:    model,
+		FilePath: filePath,
+		Format:   openai.AudioResponseFormatVerboseJSON,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("CreateTranscription: %w", classifyOpenAiError(err))
+	}
+
+	return &Transcription{
+		Model:    model,
+		Text:     resp.Text,
+		Duration: time.Duration(resp.Duration * float64(time.Second)),
+	}, nil
+}
+
+// classifyOpenAiError wraps the API errors into the provider-agnostic ones.
+func classifyOpenAiError(err error) error {
+	var (
+		apiErr *openai.APIError
+		reqErr *openai.RequestError
+	)
+
+	switch {
+	case errors.As(err, &apiErr) && apiErr.Code == openAiContextLengthExceeded:
+		return fmt.Errorf("%w: %v", ErrContextTooLong, err)
+	case strings.Contains(err.Error(), "reduce the length of the messages"):
+		return fmt.Errorf("%w: %v", ErrContextTooLong, err)
+	case errors.As(err, &apiErr) && isUnavailableStatus(apiErr.HTTPStatusCode):
+		return fmt.Errorf("%w: %v", ErrProviderUnavailable, err)
+	case errors.As(err, &reqErr) && isUnavailableStatus(reqErr.HTTPStatusCode):
+		return fmt.Errorf("%w: %v", ErrProviderUnavailable, err)
+	}
+
+	return classifyNetworkError(err)
+}
+
+func messagesToOpenAiMessages(messages []*store.Message) []openai.ChatCompletionMessage {
+	res := make([]openai.ChatCompletionMessage, len(messages))
+	for i, m := range messages {
+		res[i] = openai.ChatCompletionMessage{
+			Role:    m.Role,
+			Content: m.Message,
+		}
+	}
+	return res
+}
@@ -0,0 +1,97 @@
+package jeepity
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"mkuznets.com/go/jeepity/internal/store"
+)
+
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// Quota limits the usage within a day and a month. Zero values mean no limit.
+type Quota struct {
+	DailyTokens   int
+	MonthlyTokens int
+	DailyCost     float64
+	MonthlyCost   float64
+}
+
+// Quotas contains the limits for every user, every group chat, and the whole deployment.
+type Quotas struct {
+	User   Quota
+	Group  Quota
+	Global Quota
+}
+
+// QuotaError is returned when the usage exceeds the quota.
+type QuotaError struct {
+	// Global is set if the quota of the whole deployment is exceeded.
+	Global bool
+	// Group is set if the quota of the group chat is exceeded.
+	Group bool
+	// Reset is the time when the usage will be within the quota again.
+	Reset time.Time
+}
+
+func (e *QuotaError) Error() string {
+	scope := "user"
+	switch {
+	case e.Global:
+		scope = "global"
+	case e.Group:
+		scope = "group"
+	}
+	return fmt.Sprintf("%s: %s quota resets at %s", ErrQuotaExceeded, scope, e.Reset.Format(time.RFC3339))
+}
+
+func (e *QuotaError) Is(target error) bool {
+	return target == ErrQuotaExceeded
+}
+
+type usageFunc func(since time.Time) ([]*store.UsageSummary, error)
+
+// Check returns *QuotaError if the usage reported by getUsage exceeds any of the limits.
+func (q *Quota) Check(now time.Time, prices PriceTable, getUsage usageFunc) error {
+	limits := []struct {
+		period usagePeriod
+		tokens int
+		cost   float64
+	}{
+		{usagePeriodToday, q.DailyTokens, q.DailyCost},
+		{usagePeriodMonth, q.MonthlyTokens, q.MonthlyCost},
+	}
+
+	var reset time.Time
+	for _, l := range limits {
+		if l.tokens <= 0 && l.cost <= 0 {
+			continue
+		}
+
+		usage, err := getUsage(l.period.Start(now))
+		if err != nil {
+			return err
+		}
+
+		var (
+			tokens int
+			cost   float64
+		)
+		for _, u := range usage {
+			tokens += u.TotalTokens
+			cost += prices.Cost(u)
+		}
+
+		if (l.tokens > 0 && tokens >= l.tokens) || (l.cost > 0 && cost >= l.cost) {
+			if end := l.period.End(now); end.After(reset) {
+				reset = end
+			}
+		}
+	}
+
+	if !reset.IsZero() {
+		return &QuotaError{Reset: reset}
+	}
+	return nil
+}
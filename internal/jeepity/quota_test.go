@@ -0,0 +1,141 @@
+package jeepity
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"mkuznets.com/go/jeepity/internal/store"
+)
+
+func TestQuotaCheck(t *testing.T) {
+	prices := PriceTable{"gpt-4": {Prompt: 0.03, Completion: 0.06}}
+	moscow := time.FixedZone("MSK", 3*60*60)
+	errUsage := errors.New("usage failed")
+
+	tests := []struct {
+		name  string
+		quota Quota
+		now   time.Time
+		// day and month are the usage since the start of the day and the month in UTC.
+		day   []*store.UsageSummary
+		month []*store.UsageSummary
+		err   error
+
+		wantReset time.Time
+		wantErr   error
+	}{
+		{
+			name:  "no limits",
+			quota: Quota{},
+			now:   time.Date(2026, 1, 15, 12, 0, 0, 0, time.UTC),
+			day:   []*store.UsageSummary{{Model: "gpt-4", TotalTokens: 1_000_000}},
+		},
+		{
+			name:  "within the daily tokens",
+			quota: Quota{DailyTokens: 1000},
+			now:   time.Date(2026, 1, 15, 12, 0, 0, 0, time.UTC),
+			day:   []*store.UsageSummary{{Model: "gpt-4", TotalTokens: 600}, {Model: "gpt-3.5-turbo", TotalTokens: 399}},
+		},
+		{
+			name:      "daily tokens reached",
+			quota:     Quota{DailyTokens: 1000},
+			now:       time.Date(2026, 1, 15, 12, 0, 0, 0, time.UTC),
+			day:       []*store.UsageSummary{{Model: "gpt-4", TotalTokens: 600}, {Model: "gpt-3.5-turbo", TotalTokens: 400}},
+			wantReset: time.Date(2026, 1, 16, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:      "daily quota resets at midnight UTC",
+			quota:     Quota{DailyTokens: 1000},
+			now:       time.Date(2026, 1, 16, 1, 30, 0, 0, moscow),
+			day:       []*store.UsageSummary{{Model: "gpt-4", TotalTokens: 1000}},
+			wantReset: time.Date(2026, 1, 16, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:      "monthly cost exceeded",
+			quota:     Quota{DailyCost: 10, MonthlyCost: 5},
+			now:       time.Date(2026, 1, 31, 23, 59, 0, 0, time.UTC),
+			day:       []*store.UsageSummary{{Model: "gpt-4", PromptTokens: 1000, CompletionTokens: 1000}},
+			month:     []*store.UsageSummary{{Model: "gpt-4", PromptTokens: 100_000, CompletionTokens: 50_000}},
+			wantReset: time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:      "monthly quota resets on the first day of the next month in UTC",
+			quota:     Quota{MonthlyTokens: 1000},
+			now:       time.Date(2026, 3, 1, 1, 0, 0, 0, moscow),
+			month:     []*store.UsageSummary{{Model: "gpt-4", TotalTokens: 1000}},
+			wantReset: time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:      "the later reset wins",
+			quota:     Quota{DailyTokens: 100, MonthlyTokens: 1000},
+			now:       time.Date(2026, 12, 31, 12, 0, 0, 0, time.UTC),
+			day:       []*store.UsageSummary{{Model: "gpt-4", TotalTokens: 100}},
+			month:     []*store.UsageSummary{{Model: "gpt-4", TotalTokens: 1000}},
+			wantReset: time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:  "unknown models cost nothing",
+			quota: Quota{DailyCost: 0.01},
+			now:   time.Date(2026, 1, 15, 12, 0, 0, 0, time.UTC),
+			day:   []*store.UsageSummary{{Model: "llama2", PromptTokens: 100_000, CompletionTokens: 100_000}},
+		},
+		{
+			name:    "usage error",
+			quota:   Quota{DailyTokens: 1000},
+			now:     time.Date(2026, 1, 15, 12, 0, 0, 0, time.UTC),
+			err:     errUsage,
+			wantErr: errUsage,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			utc := tt.now.UTC()
+			dayStart := time.Date(utc.Year(), utc.Month(), utc.Day(), 0, 0, 0, 0, time.UTC)
+			monthStart := time.Date(utc.Year(), utc.Month(), 1, 0, 0, 0, 0, time.UTC)
+
+			getUsage := func(since time.Time) ([]*store.UsageSummary, error) {
+				switch {
+				case tt.err != nil:
+					return nil, tt.err
+				case since.Equal(dayStart):
+					return tt.day, nil
+				case since.Equal(monthStart):
+					return tt.month, nil
+				}
+				t.Fatalf("getUsage(%s), want the start of the day or the month in UTC", since)
+				return nil, nil
+			}
+
+			err := tt.quota.Check(tt.now, prices, getUsage)
+
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("err = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if tt.wantReset.IsZero() {
+				if err != nil {
+					t.Errorf("err = %v, want nil", err)
+				}
+				return
+			}
+
+			var qErr *QuotaError
+			if !errors.As(err, &qErr) {
+				t.Fatalf("err = %v, want *QuotaError", err)
+			}
+			if !errors.Is(err, ErrQuotaExceeded) {
+				t.Errorf("errors.Is(%v, ErrQuotaExceeded) = false", err)
+			}
+			if !qErr.Reset.Equal(tt.wantReset) {
+				t.Errorf("Reset = %s, want %s", qErr.Reset, tt.wantReset)
+			}
+			if qErr.Global || qErr.Group {
+				t.Errorf("QuotaError = %+v, want the user scope", qErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,198 @@
+package jeepity
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/mkuznets/telebot/v3"
+	"mkuznets.com/go/ytils/ylog"
+
+	"mkuznets.com/go/jeepity/internal/locale"
+	"mkuznets.com/go/jeepity/internal/store"
+	"mkuznets.com/go/jeepity/internal/ybot"
+)
+
+// Regenerate replaces the last reply of the dialog with a new completion
+// of the same context, editing the reply message in place.
+func (b *BotHandler) Regenerate(c telebot.Context) error {
+	ctx := ybot.Ctx(c)
+	cancel := ybot.NotifyTyping(ctx, c)
+	defer cancel()
+
+	user, ok := c.Get(ctxKeyUser).(*store.User)
+	if !ok {
+		return ErrUserNotFound
+	}
+	loc := locale.New(ybot.Lang(c))
+
+	id, err := strconv.ParseInt(c.Data(), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid message id %q: %w", c.Data(), err)
+	}
+
+	msgs, _, err := b.s.GetDialogMessages(ctx, user)
+	if err != nil {
+		return err
+	}
+
+	// Only the last reply of the current dialog can be regenerated.
+	if n := len(msgs); n == 0 || msgs[n-1].Id != id || msgs[n-1].Role != store.RoleAssistant {
+		err := b.limiter.Do(ctx, c.Chat().ID, func() error {
+			_, err := b.bot.EditReplyMarkup(c.Message(), nil)
+			return err
+		})
+		if err != nil {
+			ybot.Logger(c).Warn("remove reply menu", ylog.Err(err))
+		}
+		return c.Send(loc.RegenerateUnavailableMessage())
+	}
+
+	previousMsgs := dialogContext(msgs, len(msgs)-1)
+	for _, msg := range previousMsgs {
+		if err := b.e.DecryptMessage(user, msg); err != nil {
+			return fmt.Errorf("message id=%d DecryptMessage: %w", msg.Id, err)
+		}
+	}
+
+	// The new text reuses the messages of the previous one.
+	reply := ybot.NewReply(c.Message())
+	if last := msgs[len(msgs)-1]; last.TelegramID != 0 {
+		reply = storedReply(c.Chat().ID, last)
+	}
+	if err := b.restartReply(ctx, c, loc, reply); err != nil {
+		return err
+	}
+
+	completion, err := b.generate(ctx, c, reply, b.userModel(user), requestMessages(loc, previousMsgs))
+	if err != nil {
+		return err
+	}
+
+	assistantMsg := assistantMessage(user, completion, reply)
+	if err := b.e.EncryptMessage(user, assistantMsg); err != nil {
+		return fmt.Errorf("message encrypt: %w", err)
+	}
+
+	if err := b.s.DeleteMessage(ctx, user.ChatId, id); err != nil {
+		return fmt.Errorf("delete message: %w", err)
+	}
+	if err := b.s.PutMessages(ctx, []*store.Message{assistantMsg}); err != nil {
+		return fmt.Errorf("put messages: %w", err)
+	}
+
+	b.addReplyMenu(c, reply, assistantMsg.Id)
+
+	return b.putUsage(ctx, c, completion)
+}
+
+// EditedText re-runs the completion when the user edits the last prompt of the current dialog:
+// the prompt is replaced, the later messages are discarded, and the reply is regenerated in place.
+func (b *BotHandler) EditedText(c telebot.Context) error {
+	ctx := ybot.Ctx(c)
+	cancel := ybot.NotifyTyping(ctx, c)
+	defer cancel()
+
+	user, ok := c.Get(ctxKeyUser).(*store.User)
+	if !ok {
+		return ErrUserNotFound
+	}
+	loc := locale.New(ybot.Lang(c))
+
+	edited := c.Message()
+	text := ybot.StripMention(edited.Text, b.bot.Me.Username)
+	if user.InputState != store.InputStateEmpty || text == "" || strings.HasPrefix(text, "/") {
+		return nil
+	}
+
+	msgs, _, err := b.s.GetDialogMessages(ctx, user)
+	if err != nil {
+		return err
+	}
+
+	last := -1
+	for i := len(msgs) - 1; i >= 0; i-- {
+		if msgs[i].Role == store.RoleUser {
+			last = i
+			break
+		}
+	}
+	// Edits of the older prompts do not affect the dialog.
+	if last < 0 || msgs[last].TelegramID != edited.ID {
+		ybot.Logger(c).Debug("ignoring edit of a message that is not the last prompt")
+		return nil
+	}
+
+	var reply *ybot.Reply
+	for _, msg := range msgs[last+1:] {
+		if msg.Role == store.RoleAssistant && msg.TelegramID != 0 {
+			reply = storedReply(c.Chat().ID, msg)
+			break
+		}
+	}
+
+	previousMsgs := dialogContext(msgs, last)
+	for _, msg := range previousMsgs {
+		if err := b.e.DecryptMessage(user, msg); err != nil {
+			return fmt.Errorf("message id=%d DecryptMessage: %w", msg.Id, err)
+		}
+	}
+
+	if reply != nil {
+		err = b.restartReply(ctx, c, loc, reply)
+	} else {
+		reply, err = b.startReply(ctx, c, loc)
+	}
+	if err != nil {
+		return err
+	}
+
+	userMsg := &store.Message{
+		ChatId:     user.ChatId,
+		DialogID:   user.DialogID,
+		ThreadID:   user.ThreadID,
+		Role:       store.RoleUser,
+		Message:    promptText(c, text),
+		TelegramID: edited.ID,
+	}
+	reqMsgs := append(requestMessages(loc, previousMsgs), userMsg)
+
+	completion, err := b.generate(ctx, c, reply, b.userModel(user), reqMsgs)
+	if err != nil {
+		return err
+	}
+
+	assistantMsg := assistantMessage(user, completion, reply)
+
+	newMsgs := []*store.Message{userMsg, assistantMsg}
+	for _, msg := range newMsgs {
+		if err := b.e.EncryptMessage(user, msg); err != nil {
+			return fmt.Errorf("message encrypt: %w", err)
+		}
+	}
+
+	if err := b.s.TruncateDialog(ctx, user, msgs[last].Id); err != nil {
+		return fmt.Errorf("truncate dialog: %w", err)
+	}
+	if err := b.s.PutMessages(ctx, newMsgs); err != nil {
+		return fmt.Errorf("put messages: %w", err)
+	}
+
+	b.addReplyMenu(c, reply, assistantMsg.Id)
+
+	return b.putUsage(ctx, c, completion)
+}
+
+// addReplyMenu attaches the buttons to the last message of the reply generated for the message with the ID.
+func (b *BotHandler) addReplyMenu(c telebot.Context, reply *ybot.Reply, id int64) {
+	loc := locale.New(ybot.Lang(c))
+
+	menu := ybot.SingleButtonMenu("regenerate|"+strconv.FormatInt(id, 10), loc.RegenerateButton())
+	err := b.limiter.Do(ybot.Ctx(c), c.Chat().ID, func() error {
+		_, err := b.bot.EditReplyMarkup(reply.Last(), menu)
+		return err
+	})
+	if err != nil {
+		ybot.Logger(c).Warn("add reply menu", ylog.Err(err))
+	}
+}
@@ -0,0 +1,120 @@
+package jeepity
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/mkuznets/telebot/v3"
+
+	"mkuznets.com/go/jeepity/internal/locale"
+	"mkuznets.com/go/jeepity/internal/store"
+	"mkuznets.com/go/jeepity/internal/ybot"
+)
+
+const day = 24 * time.Hour
+
+// retentionOptions are the dialog retention choices offered in /settings.
+var retentionOptions = [][]time.Duration{
+	{15 * time.Minute, time.Hour, day},
+	{7 * day, store.DialogRetentionNever},
+	{store.DialogRetentionDefault},
+}
+
+func (b *BotHandler) CommandSettings(c telebot.Context) error {
+	text, menu, err := b.settings(c)
+	if err != nil {
+		return err
+	}
+	return c.Send(text, &telebot.SendOptions{
+		ParseMode:   telebot.ModeHTML,
+		ReplyMarkup: menu,
+	})
+}
+
+// SetDialogRetention updates the dialog retention of the user.
+// In group chats, only the admins can change it, since it applies to the whole group.
+func (b *BotHandler) SetDialogRetention(c telebot.Context) error {
+	ctx := ybot.Ctx(c)
+	user, ok := c.Get(ctxKeyUser).(*store.User)
+	if !ok {
+		return ErrUserNotFound
+	}
+	if err := requireGroupAdmin(c); err != nil {
+		return err
+	}
+
+	value, err := strconv.ParseInt(c.Data(), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid retention %q: %w", c.Data(), err)
+	}
+	retention := time.Duration(value)
+	if retention < store.DialogRetentionDefault {
+		return fmt.Errorf("invalid retention %q", c.Data())
+	}
+
+	if retention != user.DialogRetention {
+		if err := b.s.SetDialogRetention(ctx, user, retention); err != nil {
+			return fmt.Errorf("SetDialogRetention: %w", err)
+		}
+		user.DialogRetention = retention
+	}
+
+	text, menu, err := b.settings(c)
+	if err != nil {
+		return err
+	}
+	return c.Edit(text, &telebot.SendOptions{
+		ParseMode:   telebot.ModeHTML,
+		ReplyMarkup: menu,
+	})
+}
+
+func (b *BotHandler) settings(c telebot.Context) (string, *telebot.ReplyMarkup, error) {
+	user, ok := c.Get(ctxKeyUser).(*store.User)
+	if !ok {
+		return "", nil, ErrUserNotFound
+	}
+	loc := locale.New(ybot.Lang(c))
+
+	var rows [][]string
+	for _, options := range retentionOptions {
+		var row []string
+		for _, r := range options {
+			text := formatRetention(loc, r)
+			if r == store.DialogRetentionDefault {
+				text = loc.RetentionDefaultButton(formatRetention(loc, b.cfg.DialogRetention))
+			}
+			if r == user.DialogRetention {
+				text = "✅ " + text
+			}
+			row = append(row, "set_retention|"+strconv.FormatInt(int64(r), 10), text)
+		}
+		rows = append(rows, row)
+	}
+
+	text := loc.SettingsMessage(formatRetention(loc, b.dialogRetention(user)))
+	return text, ybot.MultiRowMenu(rows...), nil
+}
+
+// dialogRetention returns the effective dialog retention of the user.
+func (b *BotHandler) dialogRetention(user *store.User) time.Duration {
+	if user.DialogRetention == store.DialogRetentionDefault {
+		return b.cfg.DialogRetention
+	}
+	return user.DialogRetention
+}
+
+// formatRetention formats the retention in the largest whole units.
+func formatRetention(loc *locale.Locale, d time.Duration) string {
+	switch {
+	case d == store.DialogRetentionNever:
+		return loc.RetentionNever()
+	case d%day == 0:
+		return loc.RetentionDays(int(d / day))
+	case d%time.Hour == 0:
+		return loc.RetentionHours(int(d / time.Hour))
+	default:
+		return loc.RetentionMinutes(int(d / time.Minute))
+	}
+}
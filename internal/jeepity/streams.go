@@ -0,0 +1,133 @@
+package jeepity
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/mkuznets/telebot/v3"
+	"mkuznets.com/go/ytils/ylog"
+
+	"mkuznets.com/go/jeepity/internal/locale"
+	"mkuznets.com/go/jeepity/internal/ybot"
+)
+
+// stopGenerationButton is bypassing the per-user lock, see Configure.
+const stopGenerationButton = "stop_generation"
+
+type streamKey struct {
+	chatId int64
+	msgId  string
+}
+
+type stream struct {
+	cancel  context.CancelFunc
+	stopped bool
+}
+
+// streamRegistry keeps the running completions by chat ID and reply message,
+// so that they can be stopped from the outside.
+type streamRegistry struct {
+	mu      sync.Mutex
+	streams map[streamKey]*stream
+}
+
+func newStreamRegistry() *streamRegistry {
+	return &streamRegistry{streams: make(map[streamKey]*stream)}
+}
+
+// Start registers the completion streamed into the message and returns its context.
+// The returned function unregisters the stream and reports whether it has been stopped.
+func (r *streamRegistry) Start(ctx context.Context, msg telebot.Editable) (context.Context, func() bool) {
+	msgId, chatId := msg.MessageSig()
+	key := streamKey{chatId: chatId, msgId: msgId}
+
+	ctx, cancel := context.WithCancel(ctx)
+	s := &stream{cancel: cancel}
+
+	r.mu.Lock()
+	r.streams[key] = s
+	r.mu.Unlock()
+
+	return ctx, func() bool {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if r.streams[key] == s {
+			delete(r.streams, key)
+		}
+		cancel()
+		return s.stopped
+	}
+}
+
+// Stop cancels the completion streamed into the message.
+// It returns false if there is no such completion.
+func (r *streamRegistry) Stop(msg telebot.Editable) bool {
+	msgId, chatId := msg.MessageSig()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s, ok := r.streams[streamKey{chatId: chatId, msgId: msgId}]
+	if !ok {
+		return false
+	}
+	s.stopped = true
+	s.cancel()
+	return true
+}
+
+// StopGeneration interrupts the completion streamed into the reply with the button.
+// The handler bypasses the per-user lock held by the running completion.
+func (b *BotHandler) StopGeneration(c telebot.Context) error {
+	var msg telebot.Editable = c.Message()
+	if msgId := c.Data(); msgId != "" {
+		msg = &telebot.StoredMessage{MessageID: msgId, ChatID: c.Chat().ID}
+	}
+	if b.streams.Stop(msg) {
+		return nil
+	}
+	// The completion has already finished, but the button was not removed.
+	err := b.limiter.Do(ybot.Ctx(c), c.Chat().ID, func() error {
+		_, err := b.bot.EditReplyMarkup(c.Message(), nil)
+		return err
+	})
+	if err != nil {
+		ybot.Logger(c).Warn("remove stop button", ylog.Err(err))
+	}
+	return nil
+}
+
+// stopMenu returns the stop button of the completion streamed into the reply starting with the message.
+// The button of the placeholder, which does not know its message yet, stops the completion of its own message.
+func stopMenu(loc *locale.Locale, msg telebot.Editable) *telebot.ReplyMarkup {
+	if msg == nil {
+		return ybot.SingleButtonMenu(stopGenerationButton, loc.StopButton())
+	}
+	msgId, _ := msg.MessageSig()
+	return ybot.SingleButtonMenu(stopGenerationButton+"|"+msgId, loc.StopButton())
+}
+
+// stoppedCompletion finalizes the partial response of the stopped generation.
+// The tokens are counted locally since the provider does not report the usage.
+func (b *BotHandler) stoppedCompletion(writer *ybot.Writer, req *ChatRequest, loc *locale.Locale) *Completion {
+	response := writer.String()
+	if strings.TrimSpace(response) == "" {
+		// Keep the dialog valid for the providers that reject empty messages.
+		response = truncationMark
+	}
+
+	writer.Write("\n\n" + loc.StoppedFootnote())
+	writer.Close()
+
+	promptTokens := CountMessagesTokens(req.Model, req.Messages)
+	completionTokens := CountTokens(req.Model, response)
+
+	return &Completion{
+		Model:            req.Model,
+		Response:         response,
+		PromptTokens:     promptTokens,
+		CompletionTokens: completionTokens,
+		TotalTokens:      promptTokens + completionTokens,
+	}
+}
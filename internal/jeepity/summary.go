@@ -0,0 +1,182 @@
+package jeepity
+
+import (
+	"context"
+	"fmt"
+	"html"
+
+	"github.com/mkuznets/telebot/v3"
+	"golang.org/x/exp/slog"
+	"mkuznets.com/go/ytils/ylog"
+
+	"mkuznets.com/go/jeepity/internal/locale"
+	"mkuznets.com/go/jeepity/internal/store"
+	"mkuznets.com/go/jeepity/internal/ybot"
+)
+
+const (
+	// The dialog is summarized when it takes this share of the prompt budget.
+	summaryThreshold = 0.75
+	// Share of the prompt budget kept for the recent messages after summarization.
+	summaryKeepShare = 0.5
+)
+
+func (b *BotHandler) CommandSummary(c telebot.Context) error {
+	ctx := ybot.Ctx(c)
+	user, ok := c.Get(ctxKeyUser).(*store.User)
+	if !ok {
+		return ErrUserNotFound
+	}
+	loc := locale.New(ybot.Lang(c))
+
+	msgs, _, err := b.s.GetDialogMessages(ctx, user)
+	if err != nil {
+		return err
+	}
+
+	var summary *store.Message
+	for _, msg := range msgs {
+		if msg.Role == store.RoleSummary {
+			summary = msg
+		}
+	}
+	if summary == nil {
+		return c.Send(loc.SummaryEmpty())
+	}
+
+	if err := b.e.DecryptMessage(user, summary); err != nil {
+		return fmt.Errorf("message id=%d DecryptMessage: %w", summary.Id, err)
+	}
+
+	return c.Send(loc.SummaryMessage(html.EscapeString(summary.Message)), telebot.ModeHTML)
+}
+
+// summarize replaces the older messages of the decrypted dialog with their summary
+// if the dialog is close to the context limit of the model, and returns the updated dialog.
+func (b *BotHandler) summarize(ctx context.Context, c telebot.Context, user *store.User, model string, dialog []*store.Message) ([]*store.Message, error) {
+	if b.cfg.SummaryModel == "" {
+		return dialog, nil
+	}
+
+	budget := b.cfg.PromptBudget(model)
+	if CountMessagesTokens(model, dialog) <= int(float64(budget)*summaryThreshold) {
+		return dialog, nil
+	}
+
+	// Keep the most recent messages that fit into the share of the budget.
+	keepBudget := int(float64(budget) * summaryKeepShare)
+	tokens := tokensPerReply
+	split := len(dialog)
+	for split > 0 {
+		t := countMessageTokens(model, dialog[split-1])
+		if tokens+t > keepBudget {
+			break
+		}
+		tokens += t
+		split--
+	}
+
+	var system, old []*store.Message
+	for _, msg := range dialog[:split] {
+		if msg.Role == store.RoleSystem {
+			system = append(system, msg)
+		} else {
+			old = append(old, msg)
+		}
+	}
+	// Nothing to compress if only the previous summary is left.
+	if len(old) == 0 || (len(old) == 1 && old[0].Role == store.RoleSummary) {
+		return dialog, nil
+	}
+
+	loc := locale.New(ybot.Lang(c))
+
+	reqMsgs := []*store.Message{{Role: store.RoleSystem, Message: loc.SummaryPrompt()}}
+	reqMsgs = append(reqMsgs, requestMessages(loc, old)...)
+	reqMsgs = append(reqMsgs, &store.Message{Role: store.RoleUser, Message: loc.SummaryRequest()})
+
+	req := &ChatRequest{
+		Model:    b.cfg.SummaryModel,
+		User:     gptUser,
+		Messages: TrimMessages(b.cfg.SummaryModel, reqMsgs, b.cfg.PromptBudget(b.cfg.SummaryModel)),
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, completionTotalTimeout)
+	defer cancel()
+
+	// The older messages are trimmed from the request instead if the summary cannot be made.
+	completion, err := b.chat.ChatStream(ctx, req, func(string) {})
+	if err != nil {
+		ybot.Logger(c).Error("summary completion", ylog.Err(err), slog.String("model", b.cfg.SummaryModel))
+		return dialog, nil
+	}
+	if err := b.putUsage(ctx, c, completion); err != nil {
+		return nil, err
+	}
+
+	summary := &store.Message{
+		ChatId:   user.ChatId,
+		DialogID: user.DialogID,
+		ThreadID: user.ThreadID,
+		Role:     store.RoleSummary,
+		Message:  completion.Response,
+	}
+
+	encrypted := *summary
+	if err := b.e.EncryptMessage(user, &encrypted); err != nil {
+		return nil, fmt.Errorf("message encrypt: %w", err)
+	}
+	if err := b.s.PutSummary(ctx, &encrypted, old[len(old)-1].Id); err != nil {
+		return nil, fmt.Errorf("put summary: %w", err)
+	}
+	summary.Id = encrypted.Id
+
+	ybot.Logger(c).LogAttrs(ctx, slog.LevelDebug, "dialog summarized",
+		slog.String("model", completion.Model),
+		slog.Int("summarized_messages", len(old)),
+		slog.Int("prompt_tokens", completion.PromptTokens),
+		slog.Int("completion_tokens", completion.CompletionTokens),
+	)
+
+	res := make([]*store.Message, 0, len(system)+1+len(dialog)-split)
+	res = append(res, system...)
+	res = append(res, summary)
+	return append(res, dialog[split:]...), nil
+}
+
+// dialogContext returns the first n messages of the dialog without the summarized ones,
+// which are replaced with the summary. If the dialog is cut before some of the summarized
+// messages, the summary covers the messages that are cut off, so the summarized messages
+// are returned instead of it.
+func dialogContext(msgs []*store.Message, n int) []*store.Message {
+	stale := false
+	for _, msg := range msgs[n:] {
+		if msg.Summarized {
+			stale = true
+		}
+	}
+
+	res := make([]*store.Message, 0, n)
+	for _, msg := range msgs[:n] {
+		if (stale && msg.Role == store.RoleSummary) || (!stale && msg.Summarized) {
+			continue
+		}
+		res = append(res, msg)
+	}
+	return res
+}
+
+// requestMessages converts the stored dialog into the messages understood by the providers.
+func requestMessages(loc *locale.Locale, msgs []*store.Message) []*store.Message {
+	res := make([]*store.Message, len(msgs))
+	for i, msg := range msgs {
+		if msg.Role == store.RoleSummary {
+			m := *msg
+			m.Role = store.RoleSystem
+			m.Message = loc.SummaryContext(msg.Message)
+			msg = &m
+		}
+		res[i] = msg
+	}
+	return res
+}
@@ -0,0 +1,298 @@
+package jeepity
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/pkoukk/tiktoken-go"
+	tiktokenloader "github.com/pkoukk/tiktoken-go-loader"
+
+	"mkuznets.com/go/jeepity/internal/store"
+)
+
+const (
+	// Approximate number of letters per token in English words: most common words are a single token.
+	lettersPerToken = 8
+	// Approximate number of bytes per token in the words of other scripts.
+	bytesPerToken = 4
+	// Numbers are split into tokens of up to three digits.
+	digitsPerToken = 3
+	// Every chat message is wrapped into <|start|>{role}\n{content}<|end|>\n.
+	tokensPerMessage = 3
+	// Every reply is primed with <|start|>assistant<|message|>.
+	tokensPerReply = 3
+	// Context window of the models that are not in the table.
+	defaultContextWindow = 4096
+	// Marks the place where a message has been cut to fit into the context.
+	truncationMark = "…"
+)
+
+// DefaultContextWindows are the context sizes of the well-known models.
+var DefaultContextWindows = ContextWindows{
+	"gpt-3.5-turbo":      4096,
+	"gpt-3.5-turbo-16k":  16385,
+	"gpt-3.5-turbo-1106": 16385,
+	"gpt-4":              8192,
+	"gpt-4-32k":          32768,
+	"gpt-4-1106-preview": 128000,
+	"gpt-4-turbo":        128000,
+	"gpt-4o":             128000,
+	"claude-2":           100000,
+	"claude-instant-1":   100000,
+	"claude-3":           200000,
+	"llama2":             4096,
+	"mistral":            8192,
+}
+
+// encodings caches the BPE encodings of the models, nil for the models without a known one.
+var encodings sync.Map
+
+func init() {
+	// The BPE ranks are bundled with the binary instead of being downloaded on the first use.
+	tiktoken.SetBpeLoader(tiktokenloader.NewOfflineLoader())
+}
+
+// modelEncoding returns the BPE encoding of the OpenAI model, or nil for the models
+// whose tokenizers are not available locally.
+func modelEncoding(model string) *tiktoken.Tiktoken {
+	if enc, ok := encodings.Load(model); ok {
+		return enc.(*tiktoken.Tiktoken)
+	}
+
+	enc, err := tiktoken.EncodingForModel(model)
+	if err != nil {
+		enc = nil
+	}
+	encodings.Store(model, enc)
+	return enc
+}
+
+// ContextWindows maps models to the maximum number of tokens of the prompt and the reply.
+type ContextWindows map[string]int
+
+// ParseContextWindows parses a comma-separated list of `model=tokens` entries
+// on top of the default ones.
+func ParseContextWindows(s string) (ContextWindows, error) {
+	windows := ContextWindows{}
+	for model, size := range DefaultContextWindows {
+		windows[model] = size
+	}
+
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		model, value, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid context window entry %q: expected model=tokens", entry)
+		}
+
+		size, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || size <= 0 {
+			return nil, fmt.Errorf("invalid number of tokens in %q", entry)
+		}
+
+		windows[strings.TrimSpace(model)] = size
+	}
+
+	return windows, nil
+}
+
+// Lookup returns the context window of the model. Like prices, versioned
+// model names match the longest configured prefix.
+func (w ContextWindows) Lookup(model string) int {
+	if size, ok := w[model]; ok {
+		return size
+	}
+
+	size, prefix := defaultContextWindow, ""
+	for name, s := range w {
+		if strings.HasPrefix(model, name) && len(name) > len(prefix) {
+			size, prefix = s, name
+		}
+	}
+
+	return size
+}
+
+// TrimMessages fits the dialog into the budget of prompt tokens.
+//
+// System messages and the last message are always kept. The oldest of the
+// other messages are dropped until the dialog fits. If it still does not,
+// the last message is cut short. The original slice is not modified.
+func TrimMessages(model string, messages []*store.Message, budget int) []*store.Message {
+	if len(messages) == 0 || CountMessagesTokens(model, messages) <= budget {
+		return messages
+	}
+
+	last := messages[len(messages)-1]
+	var system, history []*store.Message
+	for _, m := range messages[:len(messages)-1] {
+		if m.Role == store.RoleSystem {
+			system = append(system, m)
+		} else {
+			history = append(history, m)
+		}
+	}
+
+	// Keep the most recent messages that fit, dropping the older ones.
+	tokens := CountMessagesTokens(model, append(system[:len(system):len(system)], last))
+	start := len(history)
+	for start > 0 {
+		t := countMessageTokens(model, history[start-1])
+		if tokens+t > budget {
+			break
+		}
+		tokens += t
+		start--
+	}
+
+	res := make([]*store.Message, 0, len(system)+len(history)+1)
+	res = append(res, system...)
+	res = append(res, history[start:]...)
+
+	if tokens > budget {
+		lastTokens := CountTokens(model, last.Message)
+		compacted := *last
+		compacted.Message = truncateTokens(model, last.Message, lastTokens-(tokens-budget)-1) + truncationMark
+		last = &compacted
+	}
+
+	return append(res, last)
+}
+
+// truncateTokens returns the longest prefix of the text that fits into the number of tokens.
+func truncateTokens(model, text string, tokens int) string {
+	if tokens < 0 {
+		tokens = 0
+	}
+	if enc := modelEncoding(model); enc != nil {
+		ids := enc.EncodeOrdinary(text)
+		if len(ids) <= tokens {
+			return text
+		}
+		// The cut may fall inside a character that takes several tokens.
+		return strings.ToValidUTF8(enc.Decode(ids[:tokens]), "")
+	}
+
+	n := 0
+	for n < len(text) {
+		size := nextPiece(text[n:])
+		t := pieceTokens(text[n : n+size])
+		if t > tokens {
+			break
+		}
+		tokens -= t
+		n += size
+	}
+	return text[:n]
+}
+
+// CountTokens returns the number of tokens in the text for the model. The OpenAI models
+// are counted with their BPE encodings, since their streaming completions do not report usage.
+// The tokenizers of the other models are not available locally, so their text is estimated
+// with estimateTokens when the backend does not report the usage either.
+func CountTokens(model, text string) int {
+	if enc := modelEncoding(model); enc != nil {
+		return len(enc.EncodeOrdinary(text))
+	}
+	return estimateTokens(text)
+}
+
+// estimateTokens estimates the number of tokens in the text by mimicking
+// the BPE pre-tokenizer: the text is split into words, numbers and
+// punctuation, and every piece is charged by its length.
+func estimateTokens(text string) int {
+	tokens := 0
+	for len(text) > 0 {
+		n := nextPiece(text)
+		tokens += pieceTokens(text[:n])
+		text = text[n:]
+	}
+	return tokens
+}
+
+// pieceTokens estimates the number of tokens in a pre-tokenized piece of the text.
+func pieceTokens(piece string) int {
+	word := strings.TrimPrefix(piece, " ")
+	if word == "" {
+		return 1
+	}
+
+	r, _ := utf8.DecodeRuneInString(word)
+	switch {
+	case unicode.IsDigit(r):
+		return (len(word) + digitsPerToken - 1) / digitsPerToken
+	case unicode.IsLetter(r) && isASCII(word):
+		return (len(word) + lettersPerToken - 1) / lettersPerToken
+	case unicode.IsLetter(r):
+		return (len(word) + bytesPerToken - 1) / bytesPerToken
+	default:
+		// Spaces and punctuation.
+		return 1
+	}
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// CountMessagesTokens returns the number of prompt tokens for the chat completion request to the model.
+func CountMessagesTokens(model string, messages []*store.Message) int {
+	tokens := tokensPerReply
+	for _, m := range messages {
+		tokens += countMessageTokens(model, m)
+	}
+	return tokens
+}
+
+func countMessageTokens(model string, m *store.Message) int {
+	return tokensPerMessage + CountTokens(model, m.Role) + CountTokens(model, m.Message)
+}
+
+// nextPiece returns the length in bytes of the next pre-tokenized piece of the text.
+func nextPiece(text string) int {
+	r, size := utf8.DecodeRuneInString(text)
+	n := size
+
+	// A single leading space is merged into the following word.
+	if r == ' ' && len(text) > n {
+		if next, _ := utf8.DecodeRuneInString(text[n:]); unicode.IsLetter(next) || unicode.IsDigit(next) {
+			r, size = utf8.DecodeRuneInString(text[n:])
+			n += size
+		}
+	}
+
+	var same func(rune) bool
+	switch {
+	case unicode.IsLetter(r):
+		same = unicode.IsLetter
+	case unicode.IsDigit(r):
+		same = unicode.IsDigit
+	case unicode.IsSpace(r):
+		same = unicode.IsSpace
+	default:
+		return n
+	}
+
+	for n < len(text) {
+		next, size := utf8.DecodeRuneInString(text[n:])
+		if !same(next) {
+			break
+		}
+		n += size
+	}
+
+	return n
+}
@@ -0,0 +1,72 @@
+package jeepity
+
+import (
+	"testing"
+
+	"mkuznets.com/go/jeepity/internal/store"
+)
+
+func TestCountTokens(t *testing.T) {
+	tests := []struct {
+		name  string
+		model string
+		text  string
+		want  int
+	}{
+		{name: "cl100k", model: "gpt-3.5-turbo", text: "Hello, world!", want: 4},
+		{name: "cl100k versioned model", model: "gpt-4-0613", text: "Привет, мир! 12345", want: 10},
+		{name: "o200k", model: "gpt-4o", text: "Привет, мир! 12345", want: 8},
+		{name: "estimate for unknown models", model: "llama2", text: "Привет, мир! 12345", want: estimateTokens("Привет, мир! 12345")},
+		{name: "empty", model: "gpt-4", text: "", want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CountTokens(tt.model, tt.text); got != tt.want {
+				t.Errorf("CountTokens(%q, %q) = %d, want %d", tt.model, tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTrimMessages(t *testing.T) {
+	const model = "gpt-3.5-turbo"
+	messages := []*store.Message{
+		{Role: store.RoleSystem, Message: "Be brief."},
+		{Role: store.RoleUser, Message: "The first question about something."},
+		{Role: store.RoleAssistant, Message: "The first answer."},
+		{Role: store.RoleUser, Message: "The second question."},
+	}
+	full := CountMessagesTokens(model, messages)
+
+	t.Run("fits", func(t *testing.T) {
+		if got := TrimMessages(model, messages, full); len(got) != len(messages) {
+			t.Errorf("len = %d, want %d", len(got), len(messages))
+		}
+	})
+
+	t.Run("oldest messages are dropped", func(t *testing.T) {
+		budget := full - countMessageTokens(model, messages[1])
+		got := TrimMessages(model, messages, budget)
+		if len(got) != 3 || got[0] != messages[0] || got[1] != messages[2] || got[2] != messages[3] {
+			t.Errorf("TrimMessages = %+v, want the system prompt and the last two messages", got)
+		}
+		if n := CountMessagesTokens(model, got); n > budget {
+			t.Errorf("tokens = %d, want at most %d", n, budget)
+		}
+	})
+
+	t.Run("last message is cut", func(t *testing.T) {
+		budget := CountMessagesTokens(model, []*store.Message{messages[0], messages[3]}) - 2
+		got := TrimMessages(model, messages, budget)
+		if len(got) != 2 || got[1].Message == messages[3].Message {
+			t.Fatalf("TrimMessages = %+v, want the system prompt and the cut last message", got)
+		}
+		if n := CountMessagesTokens(model, got); n > budget {
+			t.Errorf("tokens = %d, want at most %d", n, budget)
+		}
+		if messages[3].Message != "The second question." {
+			t.Errorf("the original message is modified: %q", messages[3].Message)
+		}
+	})
+}
@@ -0,0 +1,149 @@
+package jeepity
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"mkuznets.com/go/jeepity/internal/store"
+)
+
+const (
+	tokensPerPriceUnit  = 1000
+	secondsPerPriceUnit = 60
+
+	// audioPriceUnit marks the prices of the audio models, which are billed per minute.
+	audioPriceUnit = "min"
+)
+
+// Price is the cost of 1K prompt and completion tokens in USD.
+// The audio models are priced per minute of the audio instead.
+type Price struct {
+	Prompt     float64
+	Completion float64
+	Minute     float64
+}
+
+// PriceTable maps model names (or their prefixes) to prices.
+type PriceTable map[string]Price
+
+// ParsePriceTable parses a comma-separated list of `model=prompt/completion` entries,
+// where prompt and completion are the prices of 1K tokens in USD. The audio models
+// are given as `model=price/min`, where price is the cost of a minute in USD.
+func ParsePriceTable(s string) (PriceTable, error) {
+	prices := PriceTable{}
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		model, value, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid price entry %q: expected model=prompt/completion", entry)
+		}
+		promptValue, completionValue, ok := strings.Cut(value, "/")
+		if !ok {
+			return nil, fmt.Errorf("invalid price entry %q: expected model=prompt/completion", entry)
+		}
+
+		prompt, err := strconv.ParseFloat(strings.TrimSpace(promptValue), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid prompt price in %q: %w", entry, err)
+		}
+		if strings.TrimSpace(completionValue) == audioPriceUnit {
+			prices[strings.TrimSpace(model)] = Price{Minute: prompt}
+			continue
+		}
+		completion, err := strconv.ParseFloat(strings.TrimSpace(completionValue), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid completion price in %q: %w", entry, err)
+		}
+
+		prices[strings.TrimSpace(model)] = Price{Prompt: prompt, Completion: completion}
+	}
+
+	return prices, nil
+}
+
+// Lookup returns the price of the model. Versioned model names returned
+// by the API (e.g. gpt-4-0613) match the longest configured prefix.
+func (t PriceTable) Lookup(model string) (Price, bool) {
+	if p, ok := t[model]; ok {
+		return p, true
+	}
+
+	var (
+		price  Price
+		prefix string
+	)
+	for name, p := range t {
+		if strings.HasPrefix(model, name) && len(name) > len(prefix) {
+			price, prefix = p, name
+		}
+	}
+
+	return price, prefix != ""
+}
+
+// Cost estimates the cost of the usage in USD.
+func (t PriceTable) Cost(u *store.UsageSummary) float64 {
+	p, ok := t.Lookup(u.Model)
+	if !ok {
+		return 0
+	}
+	return (float64(u.PromptTokens)*p.Prompt+float64(u.CompletionTokens)*p.Completion)/tokensPerPriceUnit +
+		float64(u.AudioSeconds)*p.Minute/secondsPerPriceUnit
+}
+
+type usagePeriod int
+
+const (
+	usagePeriodToday usagePeriod = iota
+	usagePeriodWeek
+	usagePeriodMonth
+	usagePeriodTotal
+)
+
+var usagePeriods = []usagePeriod{
+	usagePeriodToday,
+	usagePeriodWeek,
+	usagePeriodMonth,
+	usagePeriodTotal,
+}
+
+// Start returns the beginning of the period (in UTC) that contains now.
+func (p usagePeriod) Start(now time.Time) time.Time {
+	now = now.UTC()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+	switch p {
+	case usagePeriodToday:
+		return today
+	case usagePeriodWeek:
+		// Weeks start on Monday.
+		offset := (int(today.Weekday()) + 6) % 7
+		return today.AddDate(0, 0, -offset)
+	case usagePeriodMonth:
+		return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	default:
+		return time.Unix(0, 0)
+	}
+}
+
+// End returns the end of the period (in UTC) that contains now.
+func (p usagePeriod) End(now time.Time) time.Time {
+	start := p.Start(now)
+
+	switch p {
+	case usagePeriodToday:
+		return start.AddDate(0, 0, 1)
+	case usagePeriodWeek:
+		return start.AddDate(0, 0, 7)
+	case usagePeriodMonth:
+		return start.AddDate(0, 1, 0)
+	default:
+		return time.Time{}
+	}
+}
@@ -0,0 +1,84 @@
+package jeepity
+
+import (
+	"math"
+	"testing"
+
+	"mkuznets.com/go/jeepity/internal/store"
+)
+
+func TestPriceTableLookup(t *testing.T) {
+	prices := PriceTable{
+		"gpt-4":         {Prompt: 0.03, Completion: 0.06},
+		"gpt-4-32k":     {Prompt: 0.06, Completion: 0.12},
+		"gpt-3.5-turbo": {Prompt: 0.0015, Completion: 0.002},
+		"whisper-1":     {Minute: 0.006},
+	}
+
+	tests := []struct {
+		name   string
+		model  string
+		want   Price
+		wantOk bool
+	}{
+		{name: "exact", model: "gpt-4", want: prices["gpt-4"], wantOk: true},
+		{name: "versioned", model: "gpt-4-0613", want: prices["gpt-4"], wantOk: true},
+		{name: "longest prefix", model: "gpt-4-32k-0613", want: prices["gpt-4-32k"], wantOk: true},
+		{name: "exact match of a longer name", model: "gpt-4-32k", want: prices["gpt-4-32k"], wantOk: true},
+		{name: "audio", model: "whisper-1", want: prices["whisper-1"], wantOk: true},
+		{name: "unknown", model: "llama2"},
+		{name: "shorter than the prefix", model: "gpt-3"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := prices.Lookup(tt.model)
+			if got != tt.want || ok != tt.wantOk {
+				t.Errorf("Lookup(%q) = %+v, %v, want %+v, %v", tt.model, got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestPriceTableCost(t *testing.T) {
+	prices := PriceTable{
+		"gpt-4":     {Prompt: 0.03, Completion: 0.06},
+		"gpt-4-32k": {Prompt: 0.06, Completion: 0.12},
+		"whisper-1": {Minute: 0.006},
+	}
+
+	tests := []struct {
+		name  string
+		usage *store.UsageSummary
+		want  float64
+	}{
+		{
+			name:  "tokens",
+			usage: &store.UsageSummary{Model: "gpt-4-0613", PromptTokens: 1000, CompletionTokens: 500},
+			want:  0.03 + 0.03,
+		},
+		{
+			name:  "longest prefix",
+			usage: &store.UsageSummary{Model: "gpt-4-32k-0613", PromptTokens: 1000, CompletionTokens: 500},
+			want:  0.06 + 0.06,
+		},
+		{
+			name:  "audio",
+			usage: &store.UsageSummary{Model: "whisper-1", AudioSeconds: 90},
+			want:  0.009,
+		},
+		{
+			name:  "unknown model",
+			usage: &store.UsageSummary{Model: "llama2", PromptTokens: 1000, CompletionTokens: 1000},
+			want:  0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := prices.Cost(tt.usage); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("Cost(%+v) = %v, want %v", tt.usage, got, tt.want)
+			}
+		})
+	}
+}
@@ -49,6 +49,13 @@ func (l *Locale) ErrNotApproved() string {
 	})
 }
 
+func (l *Locale) ErrNotGroupAdmin() string {
+	return l.msg(&i18n.Message{
+		ID:    "err_not_group_admin_message",
+		Other: "⛔ Only the group admins can change this setting",
+	})
+}
+
 func (l *Locale) ErrContextTooLongMessage() string {
 	return l.msg(&i18n.Message{
 		ID:    "err_context_too_long_message",
@@ -118,6 +125,13 @@ func (l *Locale) UnsupportedMessage() string {
 	})
 }
 
+func (l *Locale) TranscriptionUnavailableMessage() string {
+	return l.msg(&i18n.Message{
+		ID:    "transcription_unavailable_message",
+		Other: "🎙 Voice messages are not supported: speech recognition is not configured for this bot.",
+	})
+}
+
 func (l *Locale) ResetMessage() string {
 	return l.msg(&i18n.Message{
 		ID:    "reset_message",
@@ -190,3 +204,485 @@ func (l *Locale) SystemPromptUpdatedMessage(newPrompt string) string {
 		},
 	})
 }
+
+func (l *Locale) UsageBotCommand() string {
+	return l.msg(&i18n.Message{
+		ID:    "usage_bot_command",
+		Other: "Token usage and estimated cost",
+	})
+}
+
+func (l *Locale) UsageMessage() string {
+	return l.msg(&i18n.Message{
+		ID:    "usage_message",
+		Other: "📊 Your usage",
+	})
+}
+
+func (l *Locale) UsageTodayTitle() string {
+	return l.msg(&i18n.Message{
+		ID:    "usage_today_title",
+		Other: "Today",
+	})
+}
+
+func (l *Locale) UsageWeekTitle() string {
+	return l.msg(&i18n.Message{
+		ID:    "usage_week_title",
+		Other: "This week",
+	})
+}
+
+func (l *Locale) UsageMonthTitle() string {
+	return l.msg(&i18n.Message{
+		ID:    "usage_month_title",
+		Other: "This month",
+	})
+}
+
+func (l *Locale) UsageTotalTitle() string {
+	return l.msg(&i18n.Message{
+		ID:    "usage_total_title",
+		Other: "All time",
+	})
+}
+
+func (l *Locale) UsageEmpty() string {
+	return l.msg(&i18n.Message{
+		ID:    "usage_empty",
+		Other: "No usage",
+	})
+}
+
+func (l *Locale) UsageModelLine(model string, tokens int, cost string) string {
+	return l.cfg(&i18n.LocalizeConfig{
+		DefaultMessage: &i18n.Message{
+			ID:    "usage_model_line",
+			Other: "{{.Model}}: ~{{.Tokens}} tokens, ~${{.Cost}}",
+		},
+		TemplateData: map[string]interface{}{
+			"Model":  model,
+			"Tokens": tokens,
+			"Cost":   cost,
+		},
+	})
+}
+
+func (l *Locale) UsageAudioLine(model, minutes, cost string) string {
+	return l.cfg(&i18n.LocalizeConfig{
+		DefaultMessage: &i18n.Message{
+			ID:    "usage_audio_line",
+			Other: "{{.Model}}: {{.Minutes}} min, ~${{.Cost}}",
+		},
+		TemplateData: map[string]interface{}{
+			"Model":   model,
+			"Minutes": minutes,
+			"Cost":    cost,
+		},
+	})
+}
+
+func (l *Locale) UsageTotalLine(tokens int, cost string) string {
+	return l.cfg(&i18n.LocalizeConfig{
+		DefaultMessage: &i18n.Message{
+			ID:    "usage_total_line",
+			Other: "Total: ~{{.Tokens}} tokens, ~${{.Cost}}",
+		},
+		TemplateData: map[string]interface{}{
+			"Tokens": tokens,
+			"Cost":   cost,
+		},
+	})
+}
+
+func (l *Locale) ErrQuotaExceeded(reset string) string {
+	return l.cfg(&i18n.LocalizeConfig{
+		DefaultMessage: &i18n.Message{
+			ID:    "err_quota_exceeded_message",
+			Other: "⛔ You have reached your usage limit. It resets at {{.Reset}}",
+		},
+		TemplateData: map[string]interface{}{
+			"Reset": reset,
+		},
+	})
+}
+
+func (l *Locale) ErrGlobalQuotaExceeded(reset string) string {
+	return l.cfg(&i18n.LocalizeConfig{
+		DefaultMessage: &i18n.Message{
+			ID:    "err_global_quota_exceeded_message",
+			Other: "⛔ The bot has reached its usage limit. It resets at {{.Reset}}",
+		},
+		TemplateData: map[string]interface{}{
+			"Reset": reset,
+		},
+	})
+}
+
+func (l *Locale) ErrGroupQuotaExceeded(reset string) string {
+	return l.cfg(&i18n.LocalizeConfig{
+		DefaultMessage: &i18n.Message{
+			ID:    "err_group_quota_exceeded_message",
+			Other: "⛔ This group has reached its usage limit. It resets at {{.Reset}}",
+		},
+		TemplateData: map[string]interface{}{
+			"Reset": reset,
+		},
+	})
+}
+
+func (l *Locale) ModelBotCommand() string {
+	return l.msg(&i18n.Message{
+		ID:    "model_bot_command",
+		Other: "Choose the language model",
+	})
+}
+
+func (l *Locale) ModelMessage(currentModel string) string {
+	return l.cfg(&i18n.LocalizeConfig{
+		DefaultMessage: &i18n.Message{
+			ID:    "model_message",
+			Other: "Current model: {{.CurrentModel}}",
+		},
+		TemplateData: map[string]interface{}{
+			"CurrentModel": currentModel,
+		},
+	})
+}
+
+func (l *Locale) ModelUpdatedMessage(model string) string {
+	return l.cfg(&i18n.LocalizeConfig{
+		DefaultMessage: &i18n.Message{
+			ID:    "model_updated_message",
+			Other: "Model updated: {{.Model}}",
+		},
+		TemplateData: map[string]interface{}{
+			"Model": model,
+		},
+	})
+}
+
+func (l *Locale) ModelUnchangedMessage() string {
+	return l.msg(&i18n.Message{
+		ID:    "model_unchanged_message",
+		Other: "Model not changed",
+	})
+}
+
+func (l *Locale) ModelNotAvailableMessage() string {
+	return l.msg(&i18n.Message{
+		ID:    "model_not_available_message",
+		Other: "⛔ This model is not available",
+	})
+}
+
+func (l *Locale) FallbackFootnote(model string) string {
+	return l.cfg(&i18n.LocalizeConfig{
+		DefaultMessage: &i18n.Message{
+			ID:    "fallback_footnote",
+			Other: "ℹ️ The primary model is unavailable, answered by {{.Model}}",
+		},
+		TemplateData: map[string]interface{}{
+			"Model": model,
+		},
+	})
+}
+
+func (l *Locale) SummaryBotCommand() string {
+	return l.msg(&i18n.Message{
+		ID:    "summary_bot_command",
+		Other: "Show the summary of the conversation",
+	})
+}
+
+func (l *Locale) SummaryMessage(summary string) string {
+	return l.cfg(&i18n.LocalizeConfig{
+		DefaultMessage: &i18n.Message{
+			ID:    "summary_message",
+			Other: "📝 <b>Summary of the earlier messages</b>\n\n{{.Summary}}",
+		},
+		TemplateData: map[string]interface{}{
+			"Summary": summary,
+		},
+	})
+}
+
+func (l *Locale) SummaryEmpty() string {
+	return l.msg(&i18n.Message{
+		ID:    "summary_empty",
+		Other: "The conversation has not been summarized yet",
+	})
+}
+
+func (l *Locale) SummaryPrompt() string {
+	return l.msg(&i18n.Message{
+		ID:    "summary_prompt",
+		Other: "Summarize the conversation between the user and the assistant.",
+	})
+}
+
+func (l *Locale) SummaryRequest() string {
+	return l.msg(&i18n.Message{
+		ID:    "summary_request",
+		Other: "Summarize the conversation above.",
+	})
+}
+
+func (l *Locale) SummaryContext(summary string) string {
+	return l.cfg(&i18n.LocalizeConfig{
+		DefaultMessage: &i18n.Message{
+			ID:    "summary_context",
+			Other: "The earlier part of the conversation has been summarized:\n\n{{.Summary}}",
+		},
+		TemplateData: map[string]interface{}{
+			"Summary": summary,
+		},
+	})
+}
+
+func (l *Locale) HistoryBotCommand() string {
+	return l.msg(&i18n.Message{
+		ID:    "history_bot_command",
+		Other: "Browse past conversations",
+	})
+}
+
+func (l *Locale) HistoryMessage(page, pages int) string {
+	return l.cfg(&i18n.LocalizeConfig{
+		DefaultMessage: &i18n.Message{
+			ID:    "history_message",
+			Other: "🗂 <b>Past conversations</b> ({{.Page}}/{{.Pages}})",
+		},
+		TemplateData: map[string]interface{}{
+			"Page":  page,
+			"Pages": pages,
+		},
+	})
+}
+
+func (l *Locale) HistoryEmpty() string {
+	return l.msg(&i18n.Message{
+		ID:    "history_empty",
+		Other: "🗂 No past conversations",
+	})
+}
+
+func (l *Locale) HistoryUntitled() string {
+	return l.msg(&i18n.Message{
+		ID:    "history_untitled",
+		Other: "Untitled",
+	})
+}
+
+func (l *Locale) HistoryDialogLine(n int, title, createdAt string, messages int) string {
+	return l.cfg(&i18n.LocalizeConfig{
+		DefaultMessage: &i18n.Message{
+			ID:    "history_dialog_line",
+			Other: "{{.N}}. <b>{{.Title}}</b>\n<i>{{.CreatedAt}} · 💬 {{.Messages}}</i>",
+		},
+		TemplateData: map[string]interface{}{
+			"N":         n,
+			"Title":     title,
+			"CreatedAt": createdAt,
+			"Messages":  messages,
+		},
+	})
+}
+
+func (l *Locale) HistoryPrevButton() string {
+	return l.msg(&i18n.Message{
+		ID:    "history_prev_button",
+		Other: "Newer",
+	})
+}
+
+func (l *Locale) HistoryNextButton() string {
+	return l.msg(&i18n.Message{
+		ID:    "history_next_button",
+		Other: "Older",
+	})
+}
+
+func (l *Locale) HistoryKeepButton() string {
+	return l.msg(&i18n.Message{
+		ID:    "history_keep_button",
+		Other: "Keep past conversations",
+	})
+}
+
+func (l *Locale) HistoryDontKeepButton() string {
+	return l.msg(&i18n.Message{
+		ID:    "history_dont_keep_button",
+		Other: "Delete and stop keeping history",
+	})
+}
+
+func (l *Locale) ResumeBotCommand() string {
+	return l.msg(&i18n.Message{
+		ID:    "resume_bot_command",
+		Other: "Continue a past conversation",
+	})
+}
+
+func (l *Locale) ResumeMessage() string {
+	return l.msg(&i18n.Message{
+		ID:    "resume_message",
+		Other: "Choose the conversation to continue:",
+	})
+}
+
+func (l *Locale) ResumeEmpty() string {
+	return l.msg(&i18n.Message{
+		ID:    "resume_empty",
+		Other: "No past conversations to continue",
+	})
+}
+
+func (l *Locale) ResumedMessage() string {
+	return l.msg(&i18n.Message{
+		ID:    "resumed_message",
+		Other: "✅ Conversation resumed. The bot remembers its messages again.",
+	})
+}
+
+func (l *Locale) ResumeUnchangedMessage() string {
+	return l.msg(&i18n.Message{
+		ID:    "resume_unchanged_message",
+		Other: "This conversation is already active",
+	})
+}
+
+func (l *Locale) ResumeNotFoundMessage() string {
+	return l.msg(&i18n.Message{
+		ID:    "resume_not_found_message",
+		Other: "⛔ This conversation no longer exists",
+	})
+}
+
+func (l *Locale) DialogExpiredMessage(retention string) string {
+	return l.cfg(&i18n.LocalizeConfig{
+		DefaultMessage: &i18n.Message{
+			ID:    "dialog_expired_message",
+			Other: "ℹ️ The previous conversation expired after {{.Retention}} of inactivity, so this is a new one.",
+		},
+		TemplateData: map[string]interface{}{
+			"Retention": retention,
+		},
+	})
+}
+
+func (l *Locale) DialogExpiredResumeHint() string {
+	return l.msg(&i18n.Message{
+		ID:    "dialog_expired_resume_hint",
+		Other: "Use /resume to continue the previous one.",
+	})
+}
+
+func (l *Locale) SettingsBotCommand() string {
+	return l.msg(&i18n.Message{
+		ID:    "settings_bot_command",
+		Other: "Settings",
+	})
+}
+
+func (l *Locale) SettingsMessage(retention string) string {
+	return l.cfg(&i18n.LocalizeConfig{
+		DefaultMessage: &i18n.Message{
+			ID:    "settings_message",
+			Other: "⚙️ <b>Settings</b>\n\nForget the conversation after inactivity: <b>{{.Retention}}</b>",
+		},
+		TemplateData: map[string]interface{}{
+			"Retention": retention,
+		},
+	})
+}
+
+func (l *Locale) RetentionDefaultButton(retention string) string {
+	return l.cfg(&i18n.LocalizeConfig{
+		DefaultMessage: &i18n.Message{
+			ID:    "retention_default_button",
+			Other: "Default ({{.Retention}})",
+		},
+		TemplateData: map[string]interface{}{
+			"Retention": retention,
+		},
+	})
+}
+
+func (l *Locale) RetentionNever() string {
+	return l.msg(&i18n.Message{
+		ID:    "retention_never",
+		Other: "never",
+	})
+}
+
+func (l *Locale) RetentionMinutes(n int) string {
+	return l.cfg(&i18n.LocalizeConfig{
+		DefaultMessage: &i18n.Message{
+			ID:    "retention_minutes",
+			Other: "{{.N}} min",
+		},
+		TemplateData: map[string]interface{}{
+			"N": n,
+		},
+	})
+}
+
+func (l *Locale) RetentionHours(n int) string {
+	return l.cfg(&i18n.LocalizeConfig{
+		DefaultMessage: &i18n.Message{
+			ID:    "retention_hours",
+			Other: "{{.N}} h",
+		},
+		TemplateData: map[string]interface{}{
+			"N": n,
+		},
+	})
+}
+
+func (l *Locale) RetentionDays(n int) string {
+	return l.cfg(&i18n.LocalizeConfig{
+		DefaultMessage: &i18n.Message{
+			ID:    "retention_days",
+			Other: "{{.N}} d",
+		},
+		TemplateData: map[string]interface{}{
+			"N": n,
+		},
+	})
+}
+
+func (l *Locale) RegenerateButton() string {
+	return l.msg(&i18n.Message{
+		ID:    "regenerate_button",
+		Other: "🔄 Regenerate",
+	})
+}
+
+func (l *Locale) RegenerateUnavailableMessage() string {
+	return l.msg(&i18n.Message{
+		ID:    "regenerate_unavailable_message",
+		Other: "⛔ Only the last reply of the current conversation can be regenerated",
+	})
+}
+
+func (l *Locale) StopButton() string {
+	return l.msg(&i18n.Message{
+		ID:    "stop_button",
+		Other: "⏹ Stop",
+	})
+}
+
+func (l *Locale) StoppedFootnote() string {
+	return l.msg(&i18n.Message{
+		ID:    "stopped_footnote",
+		Other: "⏹ Stopped",
+	})
+}
+
+func (l *Locale) GroupSystemPrompt() string {
+	return l.msg(&i18n.Message{
+		ID:    "group_system_prompt",
+		Other: "You are talking to several users in a group chat. Their messages start with the name of the speaker.",
+	})
+}
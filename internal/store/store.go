@@ -2,10 +2,17 @@ package store
 
 import (
 	"context"
+	"errors"
+	"time"
 
 	"mkuznets.com/go/ytils/ytime"
 )
 
+var (
+	ErrDialogNotFound  = errors.New("dialog not found")
+	ErrMessageNotFound = errors.New("message not found")
+)
+
 type MessageVersion int
 
 const (
@@ -14,6 +21,23 @@ const (
 	MessageVersionV2
 )
 
+// Roles of the dialog messages.
+const (
+	RoleSystem    = "system"
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+	// RoleSummary marks the summary of the earlier messages of the dialog.
+	RoleSummary = "summary"
+)
+
+// Special values of User.DialogRetention.
+const (
+	// DialogRetentionDefault means that the deployment-wide retention applies.
+	DialogRetentionDefault time.Duration = -1
+	// DialogRetentionNever means that the dialog never expires.
+	DialogRetentionNever time.Duration = 0
+)
+
 type InputState string
 
 const (
@@ -32,6 +56,11 @@ type User struct {
 	SystemPrompt string     `db:"system_prompt"`
 	InputState   InputState `db:"input_state"`
 	DialogID     string     `db:"dialog_id"`
+	KeepHistory  bool       `db:"keep_history"`
+	// DialogRetention is the time of inactivity after which the dialog expires.
+	DialogRetention time.Duration `db:"dialog_retention"`
+	// ThreadID is the forum topic of the group, see UseTopic. Zero means the whole chat.
+	ThreadID int `db:"-"`
 
 	CreatedAt ytime.Time `db:"created_at"`
 	UpdatedAt ytime.Time `db:"updated_at"`
@@ -40,21 +69,53 @@ type User struct {
 type Message struct {
 	Id        int64          `db:"id"`
 	ChatId    int64          `db:"chat_id"`
+	DialogID  string         `db:"dialog_id"`
 	Role      string         `db:"role"`
 	Message   string         `db:"message"`
 	Version   MessageVersion `db:"version"`
 	CreatedAt ytime.Time     `db:"created_at"`
+	// TelegramID is the ID of the Telegram message with the prompt or the reply, if any.
+	TelegramID int `db:"tg_message_id"`
+	// TelegramParts are the IDs of the Telegram messages with the rest of the reply
+	// that does not fit into one message.
+	TelegramParts []int `db:"-"`
+	// ThreadID is the forum topic of the dialog, if any.
+	ThreadID int `db:"thread_id"`
+	// Summarized is set if the message is replaced with the summary in the context of the dialog.
+	// The message is kept, so that the replies to it can still be found.
+	Summarized bool `db:"summarized"`
+}
+
+// Dialog describes a past conversation of the user.
+type Dialog struct {
+	DialogID     string     `db:"dialog_id"`
+	MessageCount int        `db:"message_count"`
+	CreatedAt    ytime.Time `db:"created_at"`
+	// FirstMessage is the first user message, used as the title of the dialog.
+	FirstMessage *Message `db:"-"`
 }
 
 type Usage struct {
-	Id               int        `db:"id"`
-	ChatId           int64      `db:"chat_id"`
-	UpdateId         int        `db:"update_id"`
-	Model            string     `db:"model"`
-	CompletionTokens int        `db:"completion_tokens"`
-	PromptTokens     int        `db:"prompt_tokens"`
-	TotalTokens      int        `db:"total_tokens"`
-	CreatedAt        ytime.Time `db:"created_at"`
+	Id               int    `db:"id"`
+	ChatId           int64  `db:"chat_id"`
+	UpdateId         int    `db:"update_id"`
+	Model            string `db:"model"`
+	CompletionTokens int    `db:"completion_tokens"`
+	PromptTokens     int    `db:"prompt_tokens"`
+	TotalTokens      int    `db:"total_tokens"`
+	// AudioSeconds is the duration of the transcribed audio, which is billed per minute.
+	AudioSeconds int        `db:"audio_seconds"`
+	CreatedAt    ytime.Time `db:"created_at"`
+	// SenderId is the user who spent the tokens, which in a group chat is not the chat itself.
+	SenderId int64 `db:"sender_id"`
+}
+
+type UsageSummary struct {
+	Model            string `db:"model"`
+	CompletionTokens int    `db:"completion_tokens"`
+	PromptTokens     int    `db:"prompt_tokens"`
+	TotalTokens      int    `db:"total_tokens"`
+	AudioSeconds     int    `db:"audio_seconds"`
 }
 
 type Store interface {
@@ -64,13 +125,42 @@ type Store interface {
 	EnsureInviteCode(ctx context.Context, user *User) error
 	EnsureDiglogID(ctx context.Context, user *User) error
 	ResetDiglogID(ctx context.Context, user *User) error
+	// ResumeDialog makes the past dialog of the user the current one.
+	ResumeDialog(ctx context.Context, user *User, dialogId string) error
+	// ForkDialog makes a new current dialog of the user from the dialog of the reply
+	// with the Telegram ID of any of its parts, up to and including the reply. Nothing is done and false is returned
+	// if the reply is the last message of the current dialog.
+	ForkDialog(ctx context.Context, user *User, replyId int) (bool, error)
 	CheckInviteCode(ctx context.Context, user *User, inviteCode string) error
-	SetSystemPrompt(ctx context.Context, chatId int64, prompt string) error
-	SetInputState(ctx context.Context, chatId int64, state InputState) error
+	// UseTopic switches the user, which is a forum group, to its topic: the topic has its own
+	// dialog, history, model, system prompt, and input state.
+	UseTopic(ctx context.Context, user *User, threadId int) error
+	SetSystemPrompt(ctx context.Context, user *User, prompt string) error
+	SetModel(ctx context.Context, user *User, model string) error
+	SetInputState(ctx context.Context, user *User, state InputState) error
+	SetKeepHistory(ctx context.Context, user *User, keep bool) error
+	SetDialogRetention(ctx context.Context, user *User, retention time.Duration) error
 
-	GetDialogMessages(ctx context.Context, chatId int64) ([]*Message, error)
+	// GetDialogMessages returns the messages of the current dialog of the user: the system ones,
+	// then the summary, if any, then the rest, including the summarized ones, in order.
+	// If the dialog has expired, a new one is started and expired is true.
+	GetDialogMessages(ctx context.Context, user *User) (messages []*Message, expired bool, err error)
 	PutMessages(ctx context.Context, message []*Message) error
-	ClearMessages(ctx context.Context, chatId int64) error
+	DeleteMessage(ctx context.Context, chatId, id int64) error
+	// TruncateDialog deletes the messages of the current dialog of the user starting from the message id.
+	TruncateDialog(ctx context.Context, user *User, id int64) error
+	ClearMessages(ctx context.Context, user *User) error
+	// ClearPastDialogs deletes the messages of all dialogs of the user except the current one.
+	ClearPastDialogs(ctx context.Context, user *User) error
+	// GetPastDialogs returns the dialogs of the user except the current one, most recent first.
+	GetPastDialogs(ctx context.Context, user *User, offset, limit int) ([]*Dialog, error)
+	CountPastDialogs(ctx context.Context, user *User) (int, error)
+	// PutSummary stores the summary of the non-system messages of the dialog up to the message upTo
+	// instead of the previous one, marks these messages summarized, and sets the ID of the summary.
+	PutSummary(ctx context.Context, summary *Message, upTo int64) error
 
 	PutUsage(ctx context.Context, usage *Usage) error
+	GetUsage(ctx context.Context, chatId int64, since time.Time) ([]*UsageSummary, error)
+	GetSenderUsage(ctx context.Context, senderId int64, since time.Time) ([]*UsageSummary, error)
+	GetTotalUsage(ctx context.Context, since time.Time) ([]*UsageSummary, error)
 }
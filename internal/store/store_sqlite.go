@@ -23,12 +23,13 @@ import (
 )
 
 const (
-	DialogRetention = time.Hour
-	SaltLength      = 32
+	DefaultDialogRetention = time.Hour
+	SaltLength             = 32
 )
 
 type SqliteStore struct {
 	defaultInviteCode string
+	dialogRetention   time.Duration
 	db                *sqlx.DB
 }
 
@@ -37,7 +38,10 @@ func (s *SqliteStore) init(ctx context.Context) error {
 	m.WithLogFunc(func(msg string, args ...interface{}) {
 		slog.Debug(msg, args...)
 	})
-	return m.Migrate(ctx)
+	if err := m.Migrate(ctx); err != nil {
+		return err
+	}
+	return s.enableIncrementalVacuum(ctx)
 }
 
 func (s *SqliteStore) Close() {
@@ -53,11 +57,17 @@ func (s *SqliteStore) SetDefaultInviteCode(code string) {
 	s.defaultInviteCode = code
 }
 
+// SetDefaultDialogRetention sets the time of inactivity after which dialogs expire,
+// unless the user has chosen otherwise. Zero means that dialogs never expire.
+func (s *SqliteStore) SetDefaultDialogRetention(retention time.Duration) {
+	s.dialogRetention = retention
+}
+
 func NewSqlite(path string) (*SqliteStore, error) {
 	dsn := "file:" + path + "?cache=shared&mode=rwc&_journal_mode=WAL&_synchronous=EXTRA&_writable_schema=0&_foreign_keys=1&_txlock=immediate"
 	db := sqlx.MustConnect("sqlite3", dsn)
 
-	s := &SqliteStore{db: db}
+	s := &SqliteStore{db: db, dialogRetention: DefaultDialogRetention}
 	if err := s.init(context.Background()); err != nil {
 		return nil, fmt.Errorf("sqlite store init: %w", err)
 	}
@@ -79,6 +89,8 @@ func (s *SqliteStore) GetUser(ctx context.Context, chatId int64) (*User, error)
 	    coalesce(system_prompt, '') as system_prompt,
 	    coalesce(input_state, '') as input_state,
 	    coalesce(dialog_id, '') as dialog_id,
+	    keep_history,
+	    coalesce(dialog_retention, -1) as dialog_retention,
 	    created_at,
 	    updated_at
 	FROM users WHERE chat_id = ?`
@@ -93,10 +105,11 @@ func (s *SqliteStore) GetUser(ctx context.Context, chatId int64) (*User, error)
 }
 
 // SetSystemPrompt sets the system prompt for the user.
-func (s *SqliteStore) SetSystemPrompt(ctx context.Context, chatId int64, prompt string) error {
+func (s *SqliteStore) SetSystemPrompt(ctx context.Context, user *User, prompt string) error {
 	return doTx(ctx, s.db, func(tx *sqlx.Tx) error {
-		query := `UPDATE users SET system_prompt = ? WHERE chat_id = ?`
-		_, err := tx.ExecContext(ctx, query, prompt, chatId)
+		table, where, args := settingsScope(user)
+		query := `UPDATE ` + table + ` SET system_prompt = ? WHERE ` + where
+		_, err := tx.ExecContext(ctx, query, append([]interface{}{prompt}, args...)...)
 		if err != nil {
 			return fmt.Errorf("sql: UPDATE system_prompt: %w", err)
 		}
@@ -104,10 +117,57 @@ func (s *SqliteStore) SetSystemPrompt(ctx context.Context, chatId int64, prompt
 	})
 }
 
-func (s *SqliteStore) SetInputState(ctx context.Context, chatId int64, state InputState) error {
+// SetModel sets the chat model for the user.
+func (s *SqliteStore) SetModel(ctx context.Context, user *User, model string) error {
+	return doTx(ctx, s.db, func(tx *sqlx.Tx) error {
+		table, where, args := settingsScope(user)
+		query := `UPDATE ` + table + ` SET model = ? WHERE ` + where
+		_, err := tx.ExecContext(ctx, query, append([]interface{}{model}, args...)...)
+		if err != nil {
+			return fmt.Errorf("sql: UPDATE model: %w", err)
+		}
+		return nil
+	})
+}
+
+// SetKeepHistory sets whether the past dialogs of the user are kept.
+// Unlike the dialog settings, it belongs to the whole chat, including its forum topics,
+// since the sweeper applies it per chat.
+func (s *SqliteStore) SetKeepHistory(ctx context.Context, user *User, keep bool) error {
+	return doTx(ctx, s.db, func(tx *sqlx.Tx) error {
+		query := `UPDATE users SET keep_history = ? WHERE chat_id = ?`
+		_, err := tx.ExecContext(ctx, query, keep, user.ChatId)
+		if err != nil {
+			return fmt.Errorf("sql: UPDATE keep_history: %w", err)
+		}
+		return nil
+	})
+}
+
+// SetDialogRetention sets the dialog retention of the user.
+// DialogRetentionDefault resets it to the deployment-wide one.
+// Like SetKeepHistory, it belongs to the whole chat.
+func (s *SqliteStore) SetDialogRetention(ctx context.Context, user *User, retention time.Duration) error {
+	var value interface{}
+	if retention != DialogRetentionDefault {
+		value = int64(retention)
+	}
+
+	return doTx(ctx, s.db, func(tx *sqlx.Tx) error {
+		query := `UPDATE users SET dialog_retention = ? WHERE chat_id = ?`
+		_, err := tx.ExecContext(ctx, query, value, user.ChatId)
+		if err != nil {
+			return fmt.Errorf("sql: UPDATE dialog_retention: %w", err)
+		}
+		return nil
+	})
+}
+
+func (s *SqliteStore) SetInputState(ctx context.Context, user *User, state InputState) error {
 	return doTx(ctx, s.db, func(tx *sqlx.Tx) error {
-		query := `UPDATE users SET input_state = ? WHERE chat_id = ?`
-		_, err := tx.ExecContext(ctx, query, state, chatId)
+		table, where, args := settingsScope(user)
+		query := `UPDATE ` + table + ` SET input_state = ? WHERE ` + where
+		_, err := tx.ExecContext(ctx, query, append([]interface{}{state}, args...)...)
 		if err != nil {
 			return fmt.Errorf("sql: UPDATE input_state: %w", err)
 		}
@@ -139,8 +199,9 @@ func (s *SqliteStore) EnsureDiglogID(ctx context.Context, user *User) error {
 	user.DialogID = newDialogID()
 
 	return doTx(ctx, s.db, func(tx *sqlx.Tx) error {
-		query := `UPDATE users SET dialog_id = ? WHERE chat_id = ?`
-		_, err := tx.ExecContext(ctx, query, user.DialogID, user.ChatId)
+		table, where, args := settingsScope(user)
+		query := `UPDATE ` + table + ` SET dialog_id = ?, dialog_expired_at = NULL WHERE ` + where
+		_, err := tx.ExecContext(ctx, query, append([]interface{}{user.DialogID}, args...)...)
 		if err != nil {
 			return fmt.Errorf("EnsureDiglogID: %w", err)
 		}
@@ -148,11 +209,132 @@ func (s *SqliteStore) EnsureDiglogID(ctx context.Context, user *User) error {
 	})
 }
 
+// takeDialogExpiry reports whether the sweeper has deleted the current dialog of the user
+// because it expired, and forgets it.
+func (s *SqliteStore) takeDialogExpiry(ctx context.Context, user *User) (bool, error) {
+	table, where, args := settingsScope(user)
+	query := `UPDATE ` + table + ` SET dialog_expired_at = NULL WHERE dialog_expired_at IS NOT NULL AND ` + where
+	res, err := s.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return false, fmt.Errorf("takeDialogExpiry: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("takeDialogExpiry: %w", err)
+	}
+	return n > 0, nil
+}
+
 func (s *SqliteStore) ResetDiglogID(ctx context.Context, user *User) error {
 	user.DialogID = ""
 	return s.EnsureDiglogID(ctx, user)
 }
 
+func (s *SqliteStore) ResumeDialog(ctx context.Context, user *User, dialogId string) error {
+	return doTx(ctx, s.db, func(tx *sqlx.Tx) error {
+		var count int
+		query := `SELECT COUNT(*) FROM messages WHERE chat_id = ? AND dialog_id = ? AND coalesce(thread_id, 0) = ?`
+		if err := tx.GetContext(ctx, &count, query, user.ChatId, dialogId, user.ThreadID); err != nil {
+			return fmt.Errorf("ResumeDialog: %w", err)
+		}
+		if count == 0 {
+			return ErrDialogNotFound
+		}
+
+		// The resume time keeps the dialog from expiring until the next message.
+		table, where, args := settingsScope(user)
+		query = `UPDATE ` + table + ` SET dialog_id = ?, dialog_resumed_at = ?, dialog_expired_at = NULL WHERE ` + where
+		if _, err := tx.ExecContext(ctx, query, append([]interface{}{dialogId, ytime.Now()}, args...)...); err != nil {
+			return fmt.Errorf("ResumeDialog: %w", err)
+		}
+
+		user.DialogID = dialogId
+		return nil
+	})
+}
+
+func (s *SqliteStore) ForkDialog(ctx context.Context, user *User, replyId int) (bool, error) {
+	var forked bool
+	err := doTx(ctx, s.db, func(tx *sqlx.Tx) error {
+		// The forked dialogs share the Telegram IDs, the current one takes precedence.
+		var reply Message
+		query := `
+		SELECT id, dialog_id FROM messages m
+		WHERE chat_id = ? AND coalesce(thread_id, 0) = ? AND role = ? AND (
+		    tg_message_id = ? OR
+		    EXISTS (SELECT 1 FROM message_parts p WHERE p.message_id = m.id AND p.tg_message_id = ?)
+		)
+		ORDER BY dialog_id = ? DESC, id DESC
+		LIMIT 1`
+		if err := tx.GetContext(ctx, &reply, query, user.ChatId, user.ThreadID, RoleAssistant, replyId, replyId, user.DialogID); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrMessageNotFound
+			}
+			return fmt.Errorf("ForkDialog: %w", err)
+		}
+
+		if reply.DialogID == user.DialogID {
+			var later int
+			query = `SELECT COUNT(*) FROM messages WHERE chat_id = ? AND dialog_id = ? AND id > ? AND role != ?`
+			if err := tx.GetContext(ctx, &later, query, user.ChatId, user.DialogID, reply.Id, RoleSummary); err != nil {
+				return fmt.Errorf("ForkDialog: %w", err)
+			}
+			if later == 0 {
+				return nil
+			}
+		}
+
+		var ids []int64
+		query = `SELECT id FROM messages WHERE chat_id = ? AND dialog_id = ? AND id <= ? ORDER BY id ASC`
+		if err := tx.SelectContext(ctx, &ids, query, user.ChatId, reply.DialogID, reply.Id); err != nil {
+			return fmt.Errorf("ForkDialog: %w", err)
+		}
+
+		dialogId := newDialogID()
+		for _, id := range ids {
+			query = `
+			INSERT INTO messages (chat_id, dialog_id, role, message, created_at, version, tg_message_id, thread_id, summarized)
+			SELECT chat_id, ?, role, message, created_at, version, tg_message_id, thread_id, summarized
+			FROM messages
+			WHERE id = ?`
+			res, err := tx.ExecContext(ctx, query, dialogId, id)
+			if err != nil {
+				return fmt.Errorf("ForkDialog: %w", err)
+			}
+			copyId, err := res.LastInsertId()
+			if err != nil {
+				return fmt.Errorf("ForkDialog: %w", err)
+			}
+
+			query = `
+			INSERT INTO message_parts (message_id, part, tg_message_id)
+			SELECT ?, part, tg_message_id FROM message_parts WHERE message_id = ?`
+			if _, err := tx.ExecContext(ctx, query, copyId, id); err != nil {
+				return fmt.Errorf("ForkDialog: %w", err)
+			}
+		}
+		// The summary made after the reply is not copied.
+		if err := resetSummarized(ctx, tx, user.ChatId, dialogId); err != nil {
+			return fmt.Errorf("ForkDialog: %w", err)
+		}
+
+		// Like a resumed dialog, the fork does not expire until the next message.
+		table, where, args := settingsScope(user)
+		query = `UPDATE ` + table + ` SET dialog_id = ?, dialog_resumed_at = ? WHERE ` + where
+		if _, err := tx.ExecContext(ctx, query, append([]interface{}{dialogId, ytime.Now()}, args...)...); err != nil {
+			return fmt.Errorf("ForkDialog: %w", err)
+		}
+
+		user.DialogID = dialogId
+		forked = true
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return forked, nil
+}
+
 func (s *SqliteStore) CheckInviteCode(ctx context.Context, user *User, code string) error {
 	return doTx(ctx, s.db, func(tx *sqlx.Tx) error {
 		var invitedBy int64
@@ -186,72 +368,278 @@ func (s *SqliteStore) PutUser(ctx context.Context, user *User) (*User, error) {
 	u.UpdatedAt = ytime.Now()
 	u.InviteCode = ybot.InviteCode()
 	u.DialogID = newDialogID()
+	// Keeping the past dialogs is opt-in.
+	u.KeepHistory = false
+	u.DialogRetention = DialogRetentionDefault
 
 	query := `
-	INSERT INTO users (chat_id, approved, username, full_name, created_at, updated_at, salt, model, invite_code, dialog_id)
-	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	INSERT INTO users (chat_id, approved, username, full_name, created_at, updated_at, salt, model, invite_code, dialog_id, keep_history)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	ON CONFLICT DO NOTHING`
 
-	_, err := s.db.ExecContext(ctx, query, u.ChatId, u.Approved, u.Username, u.FullName, u.CreatedAt, u.UpdatedAt, u.Salt, "", u.InviteCode, u.DialogID)
+	_, err := s.db.ExecContext(ctx, query, u.ChatId, u.Approved, u.Username, u.FullName, u.CreatedAt, u.UpdatedAt, u.Salt, "", u.InviteCode, u.DialogID, u.KeepHistory)
 
 	return &u, err
 }
 
 func (s *SqliteStore) ApproveUser(ctx context.Context, chatId int64) error {
-	query := `UPDATE users SET approved = true and updated_at = ? WHERE chat_id = ?`
+	query := `UPDATE users SET approved = true, updated_at = ? WHERE chat_id = ?`
 	_, err := s.db.ExecContext(ctx, query, ytime.Now(), chatId)
 	return err
 }
 
-func (s *SqliteStore) GetDialogMessages(ctx context.Context, chatId int64) ([]*Message, error) {
-	retentionQuery := `SELECT COUNT(*) FROM messages WHERE created_at > ? AND chat_id = ?`
-	var recentMessages int
-	retentionThreshold := ytime.New(time.Now().Add(-DialogRetention))
+// GetDialogMessages returns the messages of the current dialog of the user.
+// If the dialog has expired, a new one is started: the old one is either kept
+// in the history or deleted, depending on the user's preference.
+func (s *SqliteStore) GetDialogMessages(ctx context.Context, user *User) ([]*Message, bool, error) {
+	dialogQuery := `
+	SELECT
+	    id, chat_id, dialog_id, role, message, created_at, version,
+	    coalesce(tg_message_id, 0) AS tg_message_id,
+	    coalesce(thread_id, 0) AS thread_id,
+	    summarized
+	FROM messages
+	WHERE chat_id = ? AND dialog_id = ?
+	ORDER BY role != ?, role != ?, id ASC`
 
-	if err := s.db.QueryRowxContext(ctx, retentionQuery, retentionThreshold, chatId).Scan(&recentMessages); err != nil {
-		return nil, err
+	var messages []*Message
+	if err := s.db.SelectContext(ctx, &messages, dialogQuery, user.ChatId, user.DialogID, RoleSystem, RoleSummary); err != nil {
+		return nil, false, err
+	}
+	if err := s.getMessageParts(ctx, user, messages); err != nil {
+		return nil, false, err
 	}
 
-	if recentMessages == 0 {
-		if err := s.ClearMessages(ctx, chatId); err != nil {
-			return nil, fmt.Errorf("ClearMessages: %w", err)
+	retention := s.dialogRetention
+	if user.DialogRetention != DialogRetentionDefault {
+		retention = user.DialogRetention
+	}
+	if len(messages) == 0 {
+		// The sweeper may have deleted the messages of the expired dialog.
+		expired, err := s.takeDialogExpiry(ctx, user)
+		if err != nil {
+			return nil, false, err
 		}
-		return nil, nil
+		if expired {
+			if err := s.ResetDiglogID(ctx, user); err != nil {
+				return nil, false, fmt.Errorf("ResetDiglogID: %w", err)
+			}
+		}
+		return messages, expired, nil
+	}
+	if retention == DialogRetentionNever {
+		return messages, false, nil
 	}
 
-	dialogQuery := `
-	SELECT id, chat_id, role, message, created_at, version
-	FROM messages
-	WHERE chat_id = ?
-	ORDER BY id ASC`
+	// A resumed dialog counts as recent, even though its messages are old.
+	table, where, args := settingsScope(user)
+	retentionQuery := `
+	SELECT
+	    (SELECT COUNT(*) FROM messages WHERE created_at > ? AND chat_id = ? AND dialog_id = ?) +
+	    (SELECT COUNT(*) FROM ` + table + ` WHERE dialog_resumed_at > ? AND ` + where + `)`
+	var recentMessages int
+	retentionThreshold := ytime.New(time.Now().Add(-retention))
 
-	var messages []*Message
-	if err := s.db.SelectContext(ctx, &messages, dialogQuery, chatId); err != nil {
-		return nil, err
+	queryArgs := append([]interface{}{retentionThreshold, user.ChatId, user.DialogID, retentionThreshold}, args...)
+	err := s.db.QueryRowxContext(ctx, retentionQuery, queryArgs...).Scan(&recentMessages)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if recentMessages == 0 {
+		if !user.KeepHistory {
+			if err := s.ClearMessages(ctx, user); err != nil {
+				return nil, false, fmt.Errorf("ClearMessages: %w", err)
+			}
+		}
+		if err := s.ResetDiglogID(ctx, user); err != nil {
+			return nil, false, fmt.Errorf("ResetDiglogID: %w", err)
+		}
+		return nil, true, nil
 	}
-	return messages, nil
+
+	return messages, false, nil
 }
 
+// PutMessages stores the messages and sets their IDs.
 func (s *SqliteStore) PutMessages(ctx context.Context, messages []*Message) error {
 	return doTx(ctx, s.db, func(tx *sqlx.Tx) error {
 		query := `
-		INSERT INTO messages (chat_id, role, message, created_at, version)
-		VALUES (?, ?, ?, ?, ?)`
+		INSERT INTO messages (chat_id, dialog_id, role, message, created_at, version, tg_message_id, thread_id)
+		VALUES (?, ?, ?, ?, ?, ?, nullif(?, 0), nullif(?, 0))`
+		partQuery := `INSERT INTO message_parts (message_id, part, tg_message_id) VALUES (?, ?, ?)`
 
 		for _, msg := range messages {
 			m := *msg
 			m.CreatedAt = ytime.Now()
-			if _, err := tx.ExecContext(ctx, query, m.ChatId, m.Role, m.Message, m.CreatedAt, m.Version); err != nil {
+			res, err := tx.ExecContext(ctx, query, m.ChatId, m.DialogID, m.Role, m.Message, m.CreatedAt, m.Version, m.TelegramID, m.ThreadID)
+			if err != nil {
+				return err
+			}
+			if msg.Id, err = res.LastInsertId(); err != nil {
 				return err
 			}
+
+			// The first part is the message itself.
+			for i, tgId := range m.TelegramParts {
+				if _, err := tx.ExecContext(ctx, partQuery, msg.Id, i+1, tgId); err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	})
+}
+
+// getMessageParts sets the Telegram IDs of the parts of the messages of the current dialog of the user.
+func (s *SqliteStore) getMessageParts(ctx context.Context, user *User, messages []*Message) error {
+	query := `
+	SELECT p.message_id AS id, p.tg_message_id
+	FROM message_parts p
+	JOIN messages m ON m.id = p.message_id
+	WHERE m.chat_id = ? AND m.dialog_id = ?
+	ORDER BY p.message_id, p.part`
+
+	var parts []*Message
+	if err := s.db.SelectContext(ctx, &parts, query, user.ChatId, user.DialogID); err != nil {
+		return err
+	}
+
+	byId := make(map[int64]*Message, len(messages))
+	for _, msg := range messages {
+		byId[msg.Id] = msg
+	}
+	for _, p := range parts {
+		if msg, ok := byId[p.Id]; ok {
+			msg.TelegramParts = append(msg.TelegramParts, p.TelegramID)
 		}
+	}
+	return nil
+}
+
+func (s *SqliteStore) DeleteMessage(ctx context.Context, chatId, id int64) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM messages WHERE chat_id = ? AND id = ?`, chatId, id)
+	return err
+}
 
+func (s *SqliteStore) TruncateDialog(ctx context.Context, user *User, id int64) error {
+	return doTx(ctx, s.db, func(tx *sqlx.Tx) error {
+		query := `DELETE FROM messages WHERE chat_id = ? AND dialog_id = ? AND id >= ?`
+		if _, err := tx.ExecContext(ctx, query, user.ChatId, user.DialogID, id); err != nil {
+			return fmt.Errorf("TruncateDialog: %w", err)
+		}
+		// The summary made after the message is deleted as well.
+		if err := resetSummarized(ctx, tx, user.ChatId, user.DialogID); err != nil {
+			return fmt.Errorf("TruncateDialog: %w", err)
+		}
 		return nil
 	})
 }
 
-func (s *SqliteStore) ClearMessages(ctx context.Context, chatId int64) error {
-	_, err := s.db.ExecContext(ctx, `DELETE FROM messages WHERE chat_id = ?`, chatId)
+func (s *SqliteStore) ClearMessages(ctx context.Context, user *User) error {
+	query := `DELETE FROM messages WHERE chat_id = ? AND coalesce(thread_id, 0) = ?`
+	_, err := s.db.ExecContext(ctx, query, user.ChatId, user.ThreadID)
+	return err
+}
+
+func (s *SqliteStore) ClearPastDialogs(ctx context.Context, user *User) error {
+	query := `DELETE FROM messages WHERE chat_id = ? AND coalesce(thread_id, 0) = ? AND coalesce(dialog_id, '') != ?`
+	_, err := s.db.ExecContext(ctx, query, user.ChatId, user.ThreadID, user.DialogID)
+	return err
+}
+
+func (s *SqliteStore) GetPastDialogs(ctx context.Context, user *User, offset, limit int) ([]*Dialog, error) {
+	query := `
+	SELECT
+	    dialog_id,
+	    SUM(role IN (?, ?)) AS message_count,
+	    MIN(created_at) AS created_at
+	FROM messages
+	WHERE chat_id = ? AND coalesce(thread_id, 0) = ? AND dialog_id IS NOT NULL AND dialog_id != ?
+	GROUP BY dialog_id
+	ORDER BY MAX(id) DESC
+	LIMIT ? OFFSET ?`
+
+	var dialogs []*Dialog
+	if err := s.db.SelectContext(ctx, &dialogs, query, RoleUser, RoleAssistant, user.ChatId, user.ThreadID, user.DialogID, limit, offset); err != nil {
+		return nil, err
+	}
+
+	firstQuery := `
+	SELECT id, chat_id, dialog_id, role, message, created_at, version
+	FROM messages
+	WHERE chat_id = ? AND dialog_id = ? AND role = ?
+	ORDER BY id ASC
+	LIMIT 1`
+
+	for _, d := range dialogs {
+		var first Message
+		if err := s.db.GetContext(ctx, &first, firstQuery, user.ChatId, d.DialogID, RoleUser); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				continue
+			}
+			return nil, err
+		}
+		d.FirstMessage = &first
+	}
+
+	return dialogs, nil
+}
+
+func (s *SqliteStore) CountPastDialogs(ctx context.Context, user *User) (int, error) {
+	query := `
+	SELECT COUNT(DISTINCT dialog_id)
+	FROM messages
+	WHERE chat_id = ? AND coalesce(thread_id, 0) = ? AND dialog_id IS NOT NULL AND dialog_id != ?`
+
+	var count int
+	if err := s.db.GetContext(ctx, &count, query, user.ChatId, user.ThreadID, user.DialogID); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+func (s *SqliteStore) PutSummary(ctx context.Context, summary *Message, upTo int64) error {
+	return doTx(ctx, s.db, func(tx *sqlx.Tx) error {
+		// The new summary covers the previous one.
+		query := `DELETE FROM messages WHERE chat_id = ? AND dialog_id = ? AND role = ?`
+		if _, err := tx.ExecContext(ctx, query, summary.ChatId, summary.DialogID, RoleSummary); err != nil {
+			return fmt.Errorf("PutSummary: %w", err)
+		}
+
+		query = `UPDATE messages SET summarized = 1 WHERE chat_id = ? AND dialog_id = ? AND id <= ? AND role != ?`
+		if _, err := tx.ExecContext(ctx, query, summary.ChatId, summary.DialogID, upTo, RoleSystem); err != nil {
+			return fmt.Errorf("PutSummary: %w", err)
+		}
+
+		query = `
+		INSERT INTO messages (chat_id, dialog_id, role, message, created_at, version, thread_id)
+		VALUES (?, ?, ?, ?, ?, ?, nullif(?, 0))`
+
+		m := *summary
+		m.CreatedAt = ytime.Now()
+		res, err := tx.ExecContext(ctx, query, m.ChatId, m.DialogID, m.Role, m.Message, m.CreatedAt, m.Version, m.ThreadID)
+		if err != nil {
+			return fmt.Errorf("PutSummary: %w", err)
+		}
+		if summary.Id, err = res.LastInsertId(); err != nil {
+			return fmt.Errorf("PutSummary: %w", err)
+		}
+
+		return nil
+	})
+}
+
+// resetSummarized returns the summarized messages of the dialog to its context
+// if the dialog has no summary.
+func resetSummarized(ctx context.Context, tx *sqlx.Tx, chatId int64, dialogId string) error {
+	query := `
+	UPDATE messages SET summarized = 0
+	WHERE chat_id = ? AND dialog_id = ? AND summarized = 1 AND NOT EXISTS (
+	    SELECT 1 FROM messages WHERE chat_id = ? AND dialog_id = ? AND role = ?
+	)`
+	_, err := tx.ExecContext(ctx, query, chatId, dialogId, chatId, dialogId, RoleSummary)
 	return err
 }
 
@@ -260,16 +648,79 @@ func (s *SqliteStore) PutUsage(ctx context.Context, usage *Usage) error {
 	u.CreatedAt = ytime.Now()
 
 	query := `
-	INSERT INTO usage (chat_id, update_id, model, completion_tokens, prompt_tokens, total_tokens, created_at)
-	VALUES (?, ?, ?, ?, ?, ?, ?)`
+	INSERT INTO usage (chat_id, sender_id, update_id, model, completion_tokens, prompt_tokens, total_tokens, audio_seconds, created_at)
+	VALUES (?, nullif(?, 0), ?, ?, ?, ?, ?, ?, ?)`
 
 	_, err := s.db.ExecContext(
 		ctx, query,
-		u.ChatId, u.UpdateId, u.Model, u.CompletionTokens, u.PromptTokens, u.TotalTokens, u.CreatedAt,
+		u.ChatId, u.SenderId, u.UpdateId, u.Model, u.CompletionTokens, u.PromptTokens, u.TotalTokens, u.AudioSeconds, u.CreatedAt,
 	)
 	return err
 }
 
+// GetUsage returns the user's token usage since the given time, grouped by model.
+func (s *SqliteStore) GetUsage(ctx context.Context, chatId int64, since time.Time) ([]*UsageSummary, error) {
+	query := `
+	SELECT
+	    model,
+	    sum(completion_tokens) as completion_tokens,
+	    sum(prompt_tokens) as prompt_tokens,
+	    sum(total_tokens) as total_tokens,
+	    sum(audio_seconds) as audio_seconds
+	FROM usage
+	WHERE chat_id = ? AND created_at >= ?
+	GROUP BY model
+	ORDER BY model ASC`
+
+	var usage []*UsageSummary
+	if err := s.db.SelectContext(ctx, &usage, query, chatId, ytime.New(since)); err != nil {
+		return nil, err
+	}
+	return usage, nil
+}
+
+// GetSenderUsage returns the token usage of the user in all chats since the given time, grouped by model.
+func (s *SqliteStore) GetSenderUsage(ctx context.Context, senderId int64, since time.Time) ([]*UsageSummary, error) {
+	query := `
+	SELECT
+	    model,
+	    sum(completion_tokens) as completion_tokens,
+	    sum(prompt_tokens) as prompt_tokens,
+	    sum(total_tokens) as total_tokens,
+	    sum(audio_seconds) as audio_seconds
+	FROM usage
+	WHERE sender_id = ? AND created_at >= ?
+	GROUP BY model
+	ORDER BY model ASC`
+
+	var usage []*UsageSummary
+	if err := s.db.SelectContext(ctx, &usage, query, senderId, ytime.New(since)); err != nil {
+		return nil, err
+	}
+	return usage, nil
+}
+
+// GetTotalUsage returns the token usage of all users since the given time, grouped by model.
+func (s *SqliteStore) GetTotalUsage(ctx context.Context, since time.Time) ([]*UsageSummary, error) {
+	query := `
+	SELECT
+	    model,
+	    sum(completion_tokens) as completion_tokens,
+	    sum(prompt_tokens) as prompt_tokens,
+	    sum(total_tokens) as total_tokens,
+	    sum(audio_seconds) as audio_seconds
+	FROM usage
+	WHERE created_at >= ?
+	GROUP BY model
+	ORDER BY model ASC`
+
+	var usage []*UsageSummary
+	if err := s.db.SelectContext(ctx, &usage, query, ytime.New(since)); err != nil {
+		return nil, err
+	}
+	return usage, nil
+}
+
 func doTx(ctx context.Context, db *sqlx.DB, op func(tx *sqlx.Tx) error) error {
 	tx, err := db.BeginTxx(ctx, nil)
 	if err != nil {
@@ -0,0 +1,246 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"golang.org/x/exp/slog"
+	"mkuznets.com/go/ytils/ylog"
+	"mkuznets.com/go/ytils/ytime"
+)
+
+// autoVacuumIncremental is the value of PRAGMA auto_vacuum that allows incremental vacuum.
+const autoVacuumIncremental = 2
+
+// SweepPolicy defines how long the data is kept. Zero retention means forever.
+type SweepPolicy struct {
+	// HistoryRetention is the time after the last message when past dialogs are deleted.
+	HistoryRetention time.Duration
+	// UsageRetention is the age of the usage rows to be deleted.
+	UsageRetention time.Duration
+}
+
+// SweepStats is the number of rows removed by a sweep.
+type SweepStats struct {
+	ExpiredMessages int64
+	HistoryMessages int64
+	Usage           int64
+}
+
+// RunSweeper periodically deletes the data past its retention until the context is cancelled.
+func (s *SqliteStore) RunSweeper(ctx context.Context, interval time.Duration, policy SweepPolicy) error {
+	return ytime.NewTicker(interval).Start(ctx, func() error {
+		start := time.Now()
+
+		stats, err := s.Sweep(ctx, policy)
+		if err != nil {
+			slog.Error("sqlite sweep", ylog.Err(err))
+			return nil
+		}
+
+		if err := s.IncrementalVacuum(ctx); err != nil {
+			slog.Error("sqlite incremental vacuum", ylog.Err(err))
+		}
+
+		slog.Info("sqlite sweep",
+			slog.Int64("expired_messages", stats.ExpiredMessages),
+			slog.Int64("history_messages", stats.HistoryMessages),
+			slog.Int64("usage", stats.Usage),
+			slog.Duration("duration", time.Since(start)),
+		)
+		return nil
+	})
+}
+
+// Sweep deletes the expired dialogs of the users who do not keep the history,
+// the past and the expired current dialogs older than the history retention, and the old usage rows.
+func (s *SqliteStore) Sweep(ctx context.Context, policy SweepPolicy) (*SweepStats, error) {
+	stats := &SweepStats{}
+	now := time.Now()
+
+	err := doTx(ctx, s.db, func(tx *sqlx.Tx) error {
+		var retentions []time.Duration
+		query := `SELECT DISTINCT coalesce(dialog_retention, -1) FROM users WHERE keep_history = 0`
+		if err := tx.SelectContext(ctx, &retentions, query); err != nil {
+			return fmt.Errorf("select retentions: %w", err)
+		}
+
+		// Users who do not keep the history only have the current dialog,
+		// so all their messages are deleted once it expires. In forum groups,
+		// this waits for the current dialogs of all topics to expire.
+		expiredChats := `
+		SELECT u.chat_id FROM users u
+		WHERE u.keep_history = 0
+		  AND coalesce(u.dialog_retention, -1) = ?
+		  AND coalesce(u.dialog_resumed_at, 0) <= ?
+		  AND NOT EXISTS (SELECT 1 FROM messages m WHERE m.chat_id = u.chat_id AND m.created_at > ?)
+		  AND NOT EXISTS (SELECT 1 FROM topics t WHERE t.chat_id = u.chat_id AND coalesce(t.dialog_resumed_at, 0) > ?)`
+		query = `DELETE FROM messages WHERE chat_id IN (` + expiredChats + `)`
+
+		for _, r := range retentions {
+			retention := r
+			if retention == DialogRetentionDefault {
+				retention = s.dialogRetention
+			}
+			if retention == DialogRetentionNever {
+				continue
+			}
+
+			threshold := ytime.New(now.Add(-retention))
+			dialogs := `SELECT dialog_id FROM messages WHERE chat_id IN (` + expiredChats + `)`
+			if err := markExpired(ctx, tx, now, dialogs, r, threshold, threshold, threshold); err != nil {
+				return err
+			}
+			res, err := tx.ExecContext(ctx, query, r, threshold, threshold, threshold)
+			if err != nil {
+				return fmt.Errorf("delete expired messages: %w", err)
+			}
+			n, err := res.RowsAffected()
+			if err != nil {
+				return err
+			}
+			stats.ExpiredMessages += n
+		}
+
+		if policy.HistoryRetention > 0 {
+			historyThreshold := ytime.New(now.Add(-policy.HistoryRetention))
+
+			query = `
+			DELETE FROM messages WHERE dialog_id IN (
+			    SELECT m.dialog_id FROM messages m
+			    JOIN users u ON u.chat_id = m.chat_id
+			    WHERE m.dialog_id != coalesce(u.dialog_id, '')
+			      AND m.dialog_id NOT IN (SELECT dialog_id FROM topics WHERE dialog_id IS NOT NULL)
+			    GROUP BY m.dialog_id
+			    HAVING MAX(m.created_at) < ?
+			)`
+
+			res, err := tx.ExecContext(ctx, query, historyThreshold)
+			if err != nil {
+				return fmt.Errorf("delete history messages: %w", err)
+			}
+			if stats.HistoryMessages, err = res.RowsAffected(); err != nil {
+				return err
+			}
+
+			// The current dialog that has expired is a past one, even though it only
+			// becomes the history once the user writes again.
+			var keepRetentions []time.Duration
+			query = `SELECT DISTINCT coalesce(dialog_retention, -1) FROM users WHERE keep_history = 1`
+			if err := tx.SelectContext(ctx, &keepRetentions, query); err != nil {
+				return fmt.Errorf("select retentions: %w", err)
+			}
+
+			expiredDialogs := `
+			SELECT dialog_id FROM (
+			    SELECT u.dialog_id FROM users u
+			    WHERE u.keep_history = 1
+			      AND coalesce(u.dialog_retention, -1) = ?
+			      AND coalesce(u.dialog_resumed_at, 0) <= ?
+			    UNION
+			    SELECT t.dialog_id FROM topics t
+			    JOIN users u ON u.chat_id = t.chat_id
+			    WHERE u.keep_history = 1
+			      AND coalesce(u.dialog_retention, -1) = ?
+			      AND coalesce(t.dialog_resumed_at, 0) <= ?
+			) WHERE dialog_id IN (
+			    SELECT dialog_id FROM messages
+			    WHERE dialog_id IS NOT NULL
+			    GROUP BY dialog_id
+			    HAVING MAX(created_at) < ? AND MAX(created_at) < ?
+			)`
+			query = `DELETE FROM messages WHERE dialog_id IN (` + expiredDialogs + `)`
+
+			for _, r := range keepRetentions {
+				retention := r
+				if retention == DialogRetentionDefault {
+					retention = s.dialogRetention
+				}
+				if retention == DialogRetentionNever {
+					continue
+				}
+
+				threshold := ytime.New(now.Add(-retention))
+				args := []interface{}{r, threshold, r, threshold, threshold, historyThreshold}
+				if err := markExpired(ctx, tx, now, expiredDialogs, args...); err != nil {
+					return err
+				}
+				res, err := tx.ExecContext(ctx, query, args...)
+				if err != nil {
+					return fmt.Errorf("delete expired history messages: %w", err)
+				}
+				n, err := res.RowsAffected()
+				if err != nil {
+					return err
+				}
+				stats.HistoryMessages += n
+			}
+		}
+
+		if policy.UsageRetention > 0 {
+			query = `DELETE FROM usage WHERE created_at < ?`
+			res, err := tx.ExecContext(ctx, query, ytime.New(now.Add(-policy.UsageRetention)))
+			if err != nil {
+				return fmt.Errorf("delete usage: %w", err)
+			}
+			if stats.Usage, err = res.RowsAffected(); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+// markExpired records the expiry of the current dialogs among the ones selected by the query,
+// so that GetDialogMessages still reports it after their messages are deleted.
+func markExpired(ctx context.Context, tx *sqlx.Tx, now time.Time, dialogs string, args ...interface{}) error {
+	for _, table := range []string{"users", "topics"} {
+		query := `UPDATE ` + table + ` SET dialog_expired_at = ? WHERE dialog_id IN (` + dialogs + `)`
+		if _, err := tx.ExecContext(ctx, query, append([]interface{}{ytime.New(now)}, args...)...); err != nil {
+			return fmt.Errorf("mark expired dialogs: %w", err)
+		}
+	}
+	return nil
+}
+
+// IncrementalVacuum returns the free pages of the database to the filesystem.
+func (s *SqliteStore) IncrementalVacuum(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, `PRAGMA incremental_vacuum`)
+	return err
+}
+
+// enableIncrementalVacuum switches the database to the incremental auto-vacuum mode.
+// Existing databases have to be rebuilt with a full vacuum once.
+func (s *SqliteStore) enableIncrementalVacuum(ctx context.Context) error {
+	var mode int
+	if err := s.db.GetContext(ctx, &mode, `PRAGMA auto_vacuum`); err != nil {
+		return fmt.Errorf("get auto_vacuum: %w", err)
+	}
+	if mode == autoVacuumIncremental {
+		return nil
+	}
+
+	// The pragma only takes effect if the vacuum runs on the same connection.
+	conn, err := s.db.Connx(ctx)
+	if err != nil {
+		return fmt.Errorf("conn: %w", err)
+	}
+	defer conn.Close()
+
+	slog.Info("enabling sqlite incremental vacuum")
+	if _, err := conn.ExecContext(ctx, `PRAGMA auto_vacuum = INCREMENTAL`); err != nil {
+		return fmt.Errorf("set auto_vacuum: %w", err)
+	}
+	if _, err := conn.ExecContext(ctx, `VACUUM`); err != nil {
+		return fmt.Errorf("vacuum: %w", err)
+	}
+	return nil
+}
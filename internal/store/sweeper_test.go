@@ -0,0 +1,109 @@
+package store
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"mkuznets.com/go/ytils/ytime"
+)
+
+func newTestStore(t *testing.T) *SqliteStore {
+	t.Helper()
+
+	s, err := NewSqlite(filepath.Join(t.TempDir(), "jeepity.db"))
+	if err != nil {
+		t.Fatalf("NewSqlite: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = s.db.Close()
+	})
+	return s
+}
+
+func TestSweepExpiredDialog(t *testing.T) {
+	tests := []struct {
+		name        string
+		keepHistory bool
+		age         time.Duration
+
+		wantMessages int
+		wantExpired  bool
+	}{
+		{
+			name:         "recent dialog is kept",
+			age:          time.Minute,
+			wantMessages: 2,
+		},
+		{
+			name:        "expired dialog is reported after the sweep",
+			age:         2 * time.Hour,
+			wantExpired: true,
+		},
+		{
+			name:        "expired dialog within the history retention is kept until the next message",
+			keepHistory: true,
+			age:         2 * time.Hour,
+			wantExpired: true,
+		},
+		{
+			name:        "expired dialog past the history retention is reported after the sweep",
+			keepHistory: true,
+			age:         48 * time.Hour,
+			wantExpired: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			s := newTestStore(t)
+
+			user, err := s.PutUser(ctx, &User{ChatId: 1, Approved: true})
+			if err != nil {
+				t.Fatalf("PutUser: %v", err)
+			}
+			if err := s.EnsureDiglogID(ctx, user); err != nil {
+				t.Fatalf("EnsureDiglogID: %v", err)
+			}
+			if err := s.SetKeepHistory(ctx, user, tt.keepHistory); err != nil {
+				t.Fatalf("SetKeepHistory: %v", err)
+			}
+			user.KeepHistory = tt.keepHistory
+			dialogId := user.DialogID
+
+			err = s.PutMessages(ctx, []*Message{
+				{ChatId: user.ChatId, DialogID: dialogId, Role: RoleUser, Message: "Hi"},
+				{ChatId: user.ChatId, DialogID: dialogId, Role: RoleAssistant, Message: "Hello!"},
+			})
+			if err != nil {
+				t.Fatalf("PutMessages: %v", err)
+			}
+			createdAt := ytime.New(time.Now().Add(-tt.age))
+			if _, err := s.db.ExecContext(ctx, `UPDATE messages SET created_at = ?`, createdAt); err != nil {
+				t.Fatalf("age messages: %v", err)
+			}
+
+			if _, err := s.Sweep(ctx, SweepPolicy{HistoryRetention: 24 * time.Hour}); err != nil {
+				t.Fatalf("Sweep: %v", err)
+			}
+
+			msgs, expired, err := s.GetDialogMessages(ctx, user)
+			if err != nil {
+				t.Fatalf("GetDialogMessages: %v", err)
+			}
+			if len(msgs) != tt.wantMessages || expired != tt.wantExpired {
+				t.Errorf("GetDialogMessages = %d messages, expired %v, want %d, %v", len(msgs), expired, tt.wantMessages, tt.wantExpired)
+			}
+			if expired && user.DialogID == dialogId {
+				t.Errorf("DialogID = %q, want a new dialog", user.DialogID)
+			}
+
+			// The expiry is only reported once.
+			if _, expired, err = s.GetDialogMessages(ctx, user); err != nil || expired {
+				t.Errorf("GetDialogMessages again: expired %v, err %v, want no expiry", expired, err)
+			}
+		})
+	}
+}
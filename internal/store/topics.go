@@ -0,0 +1,50 @@
+package store
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+	"mkuznets.com/go/ytils/ytime"
+)
+
+func (s *SqliteStore) UseTopic(ctx context.Context, user *User, threadId int) error {
+	return doTx(ctx, s.db, func(tx *sqlx.Tx) error {
+		query := `
+		INSERT INTO topics (chat_id, thread_id, dialog_id, created_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT (chat_id, thread_id) DO NOTHING`
+		if _, err := tx.ExecContext(ctx, query, user.ChatId, threadId, newDialogID(), ytime.Now()); err != nil {
+			return fmt.Errorf("UseTopic: %w", err)
+		}
+
+		var topic User
+		query = `
+		SELECT
+		    coalesce(dialog_id, '') AS dialog_id,
+		    coalesce(model, '') AS model,
+		    coalesce(system_prompt, '') AS system_prompt,
+		    coalesce(input_state, '') AS input_state
+		FROM topics
+		WHERE chat_id = ? AND thread_id = ?`
+		if err := tx.GetContext(ctx, &topic, query, user.ChatId, threadId); err != nil {
+			return fmt.Errorf("UseTopic: %w", err)
+		}
+
+		user.ThreadID = threadId
+		user.DialogID = topic.DialogID
+		user.Model = topic.Model
+		user.SystemPrompt = topic.SystemPrompt
+		user.InputState = topic.InputState
+		return nil
+	})
+}
+
+// settingsScope returns the table and the condition of the row with the dialog settings of the user,
+// which belong to the topic in forum groups.
+func settingsScope(user *User) (table, where string, args []interface{}) {
+	if user.ThreadID != 0 {
+		return "topics", "chat_id = ? AND thread_id = ?", []interface{}{user.ChatId, user.ThreadID}
+	}
+	return "users", "chat_id = ?", []interface{}{user.ChatId}
+}
@@ -0,0 +1,149 @@
+package ybot
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/mkuznets/telebot/v3"
+	"mkuznets.com/go/ytils/ylog"
+)
+
+// IsGroup reports whether the update comes from a group or a supergroup.
+func IsGroup(c telebot.Context) bool {
+	chat := c.Chat()
+	return chat != nil && (chat.Type == telebot.ChatGroup || chat.Type == telebot.ChatSuperGroup)
+}
+
+// AddressedOnly skips the messages in group chats unless they are the commands,
+// replies to the bot, or mention it. Private messages are always processed.
+// In group chats, the buttons under a message replying to a member can only be pressed
+// by that member or the admins, the other buttons only by the admins. The messages sent
+// in response to an addressed message reply to it, so that their buttons belong to its sender.
+func AddressedOnly(bot *telebot.Bot, commands []string) telebot.MiddlewareFunc {
+	known := make(map[string]bool, len(commands))
+	for _, cmd := range commands {
+		known[cmd] = true
+	}
+
+	return func(next telebot.HandlerFunc) telebot.HandlerFunc {
+		return func(c telebot.Context) error {
+			msg := c.Message()
+			if !IsGroup(c) || msg == nil {
+				return next(c)
+			}
+			if c.Callback() != nil {
+				if mayPress(bot, c) {
+					return next(c)
+				}
+				return nil
+			}
+
+			addressed := false
+			switch {
+			case strings.HasPrefix(msg.Text, "/"):
+				// Unknown commands would otherwise be completed as text.
+				addressed = known[commandName(msg.Text)]
+			case msg.ReplyTo != nil && msg.ReplyTo.Sender != nil && msg.ReplyTo.Sender.ID == bot.Me.ID:
+				addressed = true
+			case Mentions(msg, bot.Me.Username):
+				addressed = true
+			}
+			if !addressed {
+				return nil
+			}
+
+			return next(&replyContext{Context: c, msg: msg})
+		}
+	}
+}
+
+var commandRe = regexp.MustCompile(`^(/\w+)(@\w+)?(\s|$)`)
+
+// commandName returns the command of the text without the username of the bot, e.g. /start for /start@bot.
+func commandName(text string) string {
+	m := commandRe.FindStringSubmatch(text)
+	if m == nil {
+		return ""
+	}
+	return m[1]
+}
+
+// mayPress reports whether the sender of the callback may press its button in the group chat.
+func mayPress(bot *telebot.Bot, c telebot.Context) bool {
+	sender := c.Sender()
+	if sender == nil {
+		return false
+	}
+	if replyTo := c.Callback().Message.ReplyTo; replyTo != nil && replyTo.Sender != nil && replyTo.Sender.ID == sender.ID {
+		return true
+	}
+
+	admin, err := IsAdmin(bot, c.Chat(), sender)
+	if err != nil {
+		Logger(c).Error("chat member", ylog.Err(err))
+		return false
+	}
+	return admin
+}
+
+// IsAdmin reports whether the user is an admin or the creator of the group chat.
+func IsAdmin(bot *telebot.Bot, chat *telebot.Chat, user *telebot.User) (bool, error) {
+	member, err := bot.ChatMemberOf(chat, user)
+	if err != nil {
+		return false, err
+	}
+	return member.Role == telebot.Administrator || member.Role == telebot.Creator, nil
+}
+
+type replyContext struct {
+	telebot.Context
+	msg *telebot.Message
+}
+
+func (c *replyContext) Send(what interface{}, opts ...interface{}) error {
+	return c.Context.Send(what, WithReply(c.msg, opts)...)
+}
+
+// WithReply makes the telebot send options reply to the message, unless they already reply to another one.
+func WithReply(msg *telebot.Message, opts []interface{}) []interface{} {
+	res := make([]interface{}, 0, len(opts)+1)
+	res = append(res, &telebot.SendOptions{ReplyTo: msg})
+	for _, o := range opts {
+		if so, ok := o.(*telebot.SendOptions); ok && so != nil && so.ReplyTo == nil {
+			cp := *so
+			cp.ReplyTo = msg
+			o = &cp
+		}
+		res = append(res, o)
+	}
+	return res
+}
+
+// Mentions reports whether the text or the caption of the message mentions the username.
+func Mentions(msg *telebot.Message, username string) bool {
+	entities := msg.Entities
+	if msg.Text == "" {
+		entities = msg.CaptionEntities
+	}
+	for _, e := range entities {
+		if e.Type == telebot.EntityMention && strings.EqualFold(msg.EntityText(e), "@"+username) {
+			return true
+		}
+	}
+	return false
+}
+
+// StripMention removes the mentions of the username from the text.
+func StripMention(text, username string) string {
+	re := regexp.MustCompile(`(?i)@` + regexp.QuoteMeta(username) + `\b`)
+	return strings.TrimSpace(re.ReplaceAllString(text, ""))
+}
+
+// SpeakerName returns the name of the user to tell the speakers apart in a group chat.
+func SpeakerName(user *telebot.User) string {
+	name := strings.TrimSpace(user.FirstName + " " + user.LastName)
+	if name == "" {
+		name = user.Username
+	}
+	return name
+}
@@ -0,0 +1,61 @@
+package ybot
+
+import (
+	"testing"
+
+	"github.com/mkuznets/telebot/v3"
+)
+
+func TestCommandName(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{name: "command", text: "/start", want: "/start"},
+		{name: "command with payload", text: "/start abc123", want: "/start"},
+		{name: "command with username", text: "/help@jeepity_bot", want: "/help"},
+		{name: "command with username and payload", text: "/prompt@jeepity_bot Be brief.", want: "/prompt"},
+		{name: "path", text: "/usr/bin is a directory", want: ""},
+		{name: "slash", text: "/ what is this?", want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := commandName(tt.text); got != tt.want {
+				t.Errorf("commandName(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithReply(t *testing.T) {
+	msg := &telebot.Message{ID: 1}
+	other := &telebot.Message{ID: 2}
+
+	tests := []struct {
+		name string
+		opts []interface{}
+		want *telebot.Message
+	}{
+		{name: "no options", want: msg},
+		{name: "send options", opts: []interface{}{&telebot.SendOptions{ThreadID: 3}}, want: msg},
+		{name: "send options replying to another message", opts: []interface{}{&telebot.SendOptions{ReplyTo: other}}, want: other},
+		{name: "other options", opts: []interface{}{telebot.ModeHTML}, want: msg},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// telebot replaces the options collected so far with *telebot.SendOptions, so the last ones win.
+			var got *telebot.Message
+			for _, o := range WithReply(msg, tt.opts) {
+				if so, ok := o.(*telebot.SendOptions); ok {
+					got = so.ReplyTo
+				}
+			}
+			if got != tt.want {
+				t.Errorf("ReplyTo = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package ybot
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/mkuznets/telebot/v3"
+)
+
+const (
+	// Telegram allows about 30 messages per second overall, one message per second
+	// in a private chat, and 20 messages per minute in a group.
+	limiterGlobalInterval  = time.Second / 30
+	limiterPrivateInterval = time.Second
+	limiterGroupInterval   = 3 * time.Second
+
+	// limiterPruneSize is the number of chats after which the idle ones are forgotten.
+	limiterPruneSize = 1000
+)
+
+// Limiter spaces out the requests of the bot to stay within the Telegram rate limits,
+// both for the whole bot and for every chat. It is safe for concurrent use.
+type Limiter struct {
+	mu sync.Mutex
+	// next is the time when the next request is allowed.
+	next  time.Time
+	chats map[int64]time.Time
+}
+
+func NewLimiter() *Limiter {
+	return &Limiter{chats: make(map[int64]time.Time)}
+}
+
+// Wait blocks until a request to the chat is allowed or the context is done.
+func (l *Limiter) Wait(ctx context.Context, chatId int64) error {
+	l.mu.Lock()
+	now := time.Now()
+
+	at := now
+	if l.next.After(at) {
+		at = l.next
+	}
+	if next := l.chats[chatId]; next.After(at) {
+		at = next
+	}
+
+	interval := limiterPrivateInterval
+	if chatId < 0 {
+		interval = limiterGroupInterval
+	}
+	l.next = at.Add(limiterGlobalInterval)
+	l.chats[chatId] = at.Add(interval)
+	l.prune(now)
+	l.mu.Unlock()
+
+	timer := time.NewTimer(at.Sub(now))
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Backoff delays the requests to the chat if the error asks to retry later.
+func (l *Limiter) Backoff(chatId int64, err error) {
+	var flood telebot.FloodError
+	if !errors.As(err, &flood) {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	at := time.Now().Add(time.Duration(flood.RetryAfter) * time.Second)
+	if at.After(l.chats[chatId]) {
+		l.chats[chatId] = at
+	}
+}
+
+// Do makes the request to the chat within the rate limits. The request is retried after
+// the delay asked by Telegram until it succeeds, fails otherwise, or the context is done.
+func (l *Limiter) Do(ctx context.Context, chatId int64, request func() error) error {
+	for {
+		if err := l.Wait(ctx, chatId); err != nil {
+			return err
+		}
+		err := request()
+		l.Backoff(chatId, err)
+		if !IsFloodError(err) {
+			return err
+		}
+	}
+}
+
+// IsFloodError reports whether Telegram asks to retry the request later.
+func IsFloodError(err error) bool {
+	var flood telebot.FloodError
+	return errors.As(err, &flood)
+}
+
+func (l *Limiter) prune(now time.Time) {
+	if len(l.chats) < limiterPruneSize {
+		return
+	}
+	for chatId, next := range l.chats {
+		if next.Before(now) {
+			delete(l.chats, chatId)
+		}
+	}
+}
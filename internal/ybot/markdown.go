@@ -1,7 +1,11 @@
 package ybot
 
 import (
+	"html"
+	"regexp"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
 func EscapeMarkdownV2(s string) string {
@@ -15,3 +19,236 @@ func EscapeMarkdownV2(s string) string {
 
 	return buf.String()
 }
+
+var (
+	headingRe  = regexp.MustCompile(`^#{1,6}\s+(.*?)\s*#*$`)
+	ruleRe     = regexp.MustCompile(`^(?:(?:-\s*){3,}|(?:\*\s*){3,}|(?:_\s*){3,})$`)
+	listItemRe = regexp.MustCompile(`^(\s*)([-*+]|\d{1,9}[.)])\s+(.*)$`)
+	tableSepRe = regexp.MustCompile(`^\|?(\s*:?-+:?\s*\|)+\s*:?-*:?\s*$`)
+)
+
+// MarkdownToHTML converts the Markdown produced by language models into the HTML
+// supported by Telegram. The result is always valid: unsupported or malformed
+// markup is kept as text. Headings become bold, tables are shown as preformatted text,
+// and an unclosed code block, e.g. of a streamed text, extends to the end.
+func MarkdownToHTML(text string) string {
+	lines := strings.Split(text, "\n")
+	out := make([]string, 0, len(lines))
+
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+
+		switch {
+		case strings.HasPrefix(trimmed, codeFence):
+			indent := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+			lang := strings.TrimSpace(strings.TrimLeft(trimmed, "`"))
+
+			var code []string
+			for i++; i < len(lines); i++ {
+				if strings.HasPrefix(strings.TrimSpace(lines[i]), codeFence) {
+					break
+				}
+				code = append(code, strings.TrimPrefix(lines[i], indent))
+			}
+			out = append(out, codeBlockHTML(lang, strings.Join(code, "\n")))
+
+		case strings.HasPrefix(trimmed, "|"):
+			var rows []string
+			for ; i < len(lines) && strings.HasPrefix(strings.TrimSpace(lines[i]), "|"); i++ {
+				row := strings.TrimSpace(lines[i])
+				if !tableSepRe.MatchString(row) {
+					rows = append(rows, row)
+				}
+			}
+			i--
+			out = append(out, codeBlockHTML("", strings.Join(rows, "\n")))
+
+		case strings.HasPrefix(trimmed, ">"):
+			var quote []string
+			for ; i < len(lines) && strings.HasPrefix(strings.TrimSpace(lines[i]), ">"); i++ {
+				q := strings.TrimPrefix(strings.TrimSpace(lines[i]), ">")
+				quote = append(quote, inlineHTML(strings.TrimPrefix(q, " ")))
+			}
+			i--
+			out = append(out, "<blockquote>"+strings.Join(quote, "\n")+"</blockquote>")
+
+		case headingRe.MatchString(trimmed):
+			out = append(out, "<b>"+inlineHTML(headingRe.FindStringSubmatch(trimmed)[1])+"</b>")
+
+		case ruleRe.MatchString(trimmed):
+			out = append(out, "———")
+
+		case listItemRe.MatchString(line):
+			m := listItemRe.FindStringSubmatch(line)
+			level := len(strings.ReplaceAll(m[1], "\t", "    ")) / 2
+			marker := m[2]
+			if !unicode.IsDigit(rune(marker[0])) {
+				marker = "•"
+				if level > 0 {
+					marker = "◦"
+				}
+			}
+			out = append(out, strings.Repeat("  ", level)+marker+" "+inlineHTML(m[3]))
+
+		default:
+			out = append(out, inlineHTML(line))
+		}
+	}
+
+	return strings.Join(out, "\n")
+}
+
+func codeBlockHTML(lang, code string) string {
+	if lang == "" {
+		return "<pre>" + html.EscapeString(code) + "</pre>"
+	}
+	return `<pre><code class="language-` + html.EscapeString(lang) + `">` + html.EscapeString(code) + "</code></pre>"
+}
+
+// inlineHTML converts the inline Markdown of a single line.
+func inlineHTML(s string) string {
+	var b strings.Builder
+
+	for i := 0; i < len(s); {
+		c := s[i]
+
+		switch {
+		case c == '\\' && i+1 < len(s) && isPunctByte(s[i+1]):
+			b.WriteString(html.EscapeString(s[i+1 : i+2]))
+			i += 2
+			continue
+
+		case c == '`':
+			marker := s[i : i+len(s[i:])-len(strings.TrimLeft(s[i:], "`"))]
+			if end := strings.Index(s[i+len(marker):], marker); end > 0 {
+				code := s[i+len(marker) : i+len(marker)+end]
+				b.WriteString("<code>" + html.EscapeString(code) + "</code>")
+				i += 2*len(marker) + end
+				continue
+			}
+			b.WriteString(marker)
+			i += len(marker)
+			continue
+
+		case c == '[':
+			if text, url, n, ok := parseLink(s[i:]); ok {
+				b.WriteString(`<a href="` + html.EscapeString(url) + `">` + inlineHTML(text) + "</a>")
+				i += n
+				continue
+			}
+
+		case c == '*' || c == '_' || c == '~':
+			if inner, marker, n, ok := parseEmphasis(s, i); ok {
+				open, closing := emphasisTags(marker)
+				b.WriteString(open + inlineHTML(inner) + closing)
+				i += n
+				continue
+			}
+			// Keep the whole run of the markers as text, so that its part is not matched later.
+			n := len(s[i:]) - len(strings.TrimLeft(s[i:], string(c)))
+			b.WriteString(s[i : i+n])
+			i += n
+			continue
+		}
+
+		b.WriteString(html.EscapeString(s[i : i+1]))
+		i++
+	}
+
+	return b.String()
+}
+
+// parseLink parses the link [text](url) at the start of s and returns its length.
+func parseLink(s string) (text, url string, n int, ok bool) {
+	mid := strings.Index(s, "](")
+	if mid < 0 {
+		return "", "", 0, false
+	}
+	// The URL may contain balanced parentheses, e.g. of Wikipedia articles.
+	end := -1
+	depth := 0
+	for j := mid + 2; j < len(s) && end < 0; j++ {
+		switch s[j] {
+		case '(':
+			depth++
+		case ')':
+			if depth == 0 {
+				end = j - mid
+			}
+			depth--
+		}
+	}
+	if end < 0 {
+		return "", "", 0, false
+	}
+	text, url = s[1:mid], s[mid+2:mid+end]
+	if text == "" || strings.ContainsAny(url, " []") {
+		return "", "", 0, false
+	}
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") && !strings.HasPrefix(url, "tg://") && !strings.HasPrefix(url, "mailto:") {
+		return "", "", 0, false
+	}
+	return text, url, mid + end + 1, true
+}
+
+// parseEmphasis parses the emphasis starting at s[i] and returns its content,
+// the marker, and the length of the emphasized text with the markers.
+func parseEmphasis(s string, i int) (inner, marker string, n int, ok bool) {
+	c := s[i]
+	run := len(s[i:]) - len(strings.TrimLeft(s[i:], string(c)))
+	if run > 3 || c == '~' && run != 2 {
+		return "", "", 0, false
+	}
+	marker = s[i : i+run]
+	// Underscores inside words, e.g. in snake_case, are not emphasis.
+	if c == '_' && i > 0 && isWordByte(s[i-1]) {
+		return "", "", 0, false
+	}
+
+	// The closing marker is a run of the same length, e.g. ** in *a **b** c* is skipped.
+	start := i + run
+	for j := start; j < len(s); {
+		k := strings.IndexByte(s[j:], c)
+		if k < 0 {
+			break
+		}
+		j += k
+		closeRun := len(s[j:]) - len(strings.TrimLeft(s[j:], string(c)))
+		if closeRun != run {
+			j += closeRun
+			continue
+		}
+
+		inner = s[start:j]
+		after := j + run
+		// Underscores closing inside a word are not emphasis either.
+		if inner == "" || strings.TrimSpace(inner) != inner || c == '_' && after < len(s) && isWordByte(s[after]) {
+			return "", "", 0, false
+		}
+		return inner, marker, after - i, true
+	}
+
+	return "", "", 0, false
+}
+
+func emphasisTags(marker string) (string, string) {
+	switch {
+	case marker == "~~":
+		return "<s>", "</s>"
+	case len(marker) == 3:
+		return "<b><i>", "</i></b>"
+	case len(marker) == 2:
+		return "<b>", "</b>"
+	default:
+		return "<i>", "</i>"
+	}
+}
+
+func isWordByte(c byte) bool {
+	return c >= utf8.RuneSelf || c == '_' || unicode.IsLetter(rune(c)) || unicode.IsDigit(rune(c))
+}
+
+func isPunctByte(c byte) bool {
+	return c < utf8.RuneSelf && (unicode.IsPunct(rune(c)) || unicode.IsSymbol(rune(c)))
+}
@@ -0,0 +1,130 @@
+package ybot
+
+import "testing"
+
+func TestMarkdownToHTML(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{
+			name: "plain text is escaped",
+			text: "a < b && c > d",
+			want: "a &lt; b &amp;&amp; c &gt; d",
+		},
+		{
+			name: "emphasis",
+			text: "*i* _i_ **b** __b__ ***bi*** ~~s~~",
+			want: "<i>i</i> <i>i</i> <b>b</b> <b>b</b> <b><i>bi</i></b> <s>s</s>",
+		},
+		{
+			name: "nested emphasis",
+			text: "**bold _italic_ bold** and *a **b** c*",
+			want: "<b>bold <i>italic</i> bold</b> and <i>a <b>b</b> c</i>",
+		},
+		{
+			name: "unclosed emphasis is kept as text",
+			text: "**bold and *italic",
+			want: "**bold and *italic",
+		},
+		{
+			name: "emphasis does not cross lines",
+			text: "**bold\nstill**",
+			want: "**bold\nstill**",
+		},
+		{
+			name: "underscores inside words",
+			text: "snake_case_name and __init__",
+			want: "snake_case_name and <b>init</b>",
+		},
+		{
+			name: "escaped markers",
+			text: `\*not italic\*`,
+			want: "*not italic*",
+		},
+		{
+			name: "inline code is not formatted",
+			text: "`**x** <y>` and ``a ` b``",
+			want: "<code>**x** &lt;y&gt;</code> and <code>a ` b</code>",
+		},
+		{
+			name: "unclosed inline code is kept as text",
+			text: "`code **b**",
+			want: "`code <b>b</b>",
+		},
+		{
+			name: "link",
+			text: "see [the *docs*](https://example.com/a?b=1&c=2)",
+			want: `see <a href="https://example.com/a?b=1&amp;c=2">the <i>docs</i></a>`,
+		},
+		{
+			name: "link with parentheses in the URL",
+			text: "[Go](https://en.wikipedia.org/wiki/Go_(programming_language)) is",
+			want: `<a href="https://en.wikipedia.org/wiki/Go_(programming_language)">Go</a> is`,
+		},
+		{
+			name: "link in parentheses",
+			text: "(see [x](https://a.b/c))",
+			want: `(see <a href="https://a.b/c">x</a>)`,
+		},
+		{
+			name: "unclosed link is kept as text",
+			text: "[x](https://a.b/(c",
+			want: "[x](https://a.b/(c",
+		},
+		{
+			name: "link with an unsupported scheme is kept as text",
+			text: "[x](javascript:alert(1))",
+			want: "[x](javascript:alert(1))",
+		},
+		{
+			name: "code block",
+			text: "```go\nif a < b {\n    **x**\n}\n```\nafter",
+			want: "<pre><code class=\"language-go\">if a &lt; b {\n    **x**\n}</code></pre>\nafter",
+		},
+		{
+			name: "indented code block",
+			text: "  ```\n  a\n    b\n  ```",
+			want: "<pre>a\n  b</pre>",
+		},
+		{
+			name: "unclosed code block extends to the end",
+			text: "text\n```python\nprint('<')\n**x**",
+			want: "text\n<pre><code class=\"language-python\">print(&#39;&lt;&#39;)\n**x**</code></pre>",
+		},
+		{
+			name: "heading",
+			text: "## Title *x* ##",
+			want: "<b>Title <i>x</i></b>",
+		},
+		{
+			name: "lists",
+			text: "- a\n  * b\n1. **c**",
+			want: "• a\n  ◦ b\n1. <b>c</b>",
+		},
+		{
+			name: "quote",
+			text: "> a\n> *b*",
+			want: "<blockquote>a\n<i>b</i></blockquote>",
+		},
+		{
+			name: "table",
+			text: "| a | b |\n|---|:-:|\n| <1> | 2 |",
+			want: "<pre>| a | b |\n| &lt;1&gt; | 2 |</pre>",
+		},
+		{
+			name: "rule",
+			text: "a\n---\nb",
+			want: "a\n———\nb",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MarkdownToHTML(tt.text); got != tt.want {
+				t.Errorf("MarkdownToHTML(%q) =\n%q\nwant\n%q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
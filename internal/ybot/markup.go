@@ -23,3 +23,22 @@ func MultiButtonMenu(arg ...string) *telebot.ReplyMarkup {
 	menu.Inline(menu.Row(buttons...))
 	return menu
 }
+
+// MultiRowMenu creates an inline menu with a row of buttons for every list of id and text pairs.
+func MultiRowMenu(rows ...[]string) *telebot.ReplyMarkup {
+	menu := &telebot.ReplyMarkup{}
+	menu.ResizeKeyboard = true
+
+	var menuRows []telebot.Row
+	for _, row := range rows {
+		var buttons []telebot.Btn
+		for i := 0; i < len(row); i += 2 {
+			buttons = append(buttons, menu.Data(row[i+1], row[i]))
+		}
+		if len(buttons) > 0 {
+			menuRows = append(menuRows, menu.Row(buttons...))
+		}
+	}
+	menu.Inline(menuRows...)
+	return menu
+}
@@ -0,0 +1,37 @@
+package ybot
+
+import (
+	"strconv"
+
+	"github.com/mkuznets/telebot/v3"
+)
+
+// Reply is a reply of the bot, which takes several messages if its text does not fit into one.
+type Reply struct {
+	// Parts are the messages with the parts of the text in order. There is at least one.
+	Parts []telebot.Editable
+}
+
+func NewReply(parts ...telebot.Editable) *Reply {
+	return &Reply{Parts: parts}
+}
+
+// First returns the message with the beginning of the reply.
+func (r *Reply) First() telebot.Editable {
+	return r.Parts[0]
+}
+
+// Last returns the message with the end of the reply.
+func (r *Reply) Last() telebot.Editable {
+	return r.Parts[len(r.Parts)-1]
+}
+
+// IDs returns the Telegram IDs of the messages of the reply.
+func (r *Reply) IDs() []int {
+	ids := make([]int, len(r.Parts))
+	for i, part := range r.Parts {
+		msgId, _ := part.MessageSig()
+		ids[i], _ = strconv.Atoi(msgId)
+	}
+	return ids
+}
@@ -0,0 +1,99 @@
+package ybot
+
+import (
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+const (
+	// MessageLimit is the maximum length of a Telegram message in UTF-16 code units.
+	MessageLimit = 4096
+
+	codeFence = "```"
+)
+
+// SplitMessage splits the text into parts that fit into the limit.
+// The text is split at paragraph, line, or word boundaries if possible.
+// A code block cut by the split is closed at the end of the part and reopened in the next one.
+func SplitMessage(text string, limit int) []string {
+	var parts []string
+
+	for {
+		if textLength(text) <= limit {
+			return append(parts, text)
+		}
+
+		end, next := splitIndex(text, limit-len("\n"+codeFence))
+		// The blank lines between the parts are dropped.
+		next += len(text[next:]) - len(strings.TrimLeft(text[next:], "\n"))
+		part, rest := text[:end], text[next:]
+
+		if fence := openFence(part); fence != "" {
+			part += "\n" + codeFence
+			rest = fence + "\n" + rest
+			// An overly long fence could take the whole next part.
+			if len(fence) >= limit/4 {
+				rest = text[next:]
+			}
+		}
+
+		parts = append(parts, part)
+		text = rest
+	}
+}
+
+// splitIndex returns the end of the first part of the text that fits into the limit
+// and the start of the rest. The boundaries in the first half of the part are ignored,
+// so that the parts are not too short.
+func splitIndex(text string, limit int) (end, next int) {
+	maxEnd := 0
+	length := 0
+	for maxEnd < len(text) {
+		r, size := utf8.DecodeRuneInString(text[maxEnd:])
+		length += utf16.RuneLen(r)
+		if length > limit {
+			break
+		}
+		maxEnd += size
+	}
+	if maxEnd == 0 {
+		// The limit is smaller than the first rune.
+		_, size := utf8.DecodeRuneInString(text)
+		return size, size
+	}
+
+	window := text[:maxEnd]
+	for _, sep := range []string{"\n\n", "\n", " "} {
+		if i := strings.LastIndex(window, sep); i > maxEnd/2 {
+			return i, i + len(sep)
+		}
+	}
+
+	return maxEnd, maxEnd
+}
+
+// openFence returns the opening line of the code block that is not closed by the end of the text.
+func openFence(text string) string {
+	var fence string
+	for _, line := range strings.Split(text, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if !strings.HasPrefix(trimmed, codeFence) {
+			continue
+		}
+		if fence == "" {
+			fence = trimmed
+		} else {
+			fence = ""
+		}
+	}
+	return fence
+}
+
+func textLength(text string) int {
+	length := 0
+	for _, r := range text {
+		length += utf16.RuneLen(r)
+	}
+	return length
+}
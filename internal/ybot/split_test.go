@@ -0,0 +1,102 @@
+package ybot
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSplitMessage(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		limit int
+		want  []string
+	}{
+		{
+			name:  "fits",
+			text:  "short text",
+			limit: 20,
+			want:  []string{"short text"},
+		},
+		{
+			name:  "paragraph boundary",
+			text:  "first paragraph\n\nsecond one",
+			limit: 20,
+			want:  []string{"first paragraph", "second one"},
+		},
+		{
+			name:  "line boundary",
+			text:  "first line\nsecond line",
+			limit: 16,
+			want:  []string{"first line", "second line"},
+		},
+		{
+			name:  "word boundary",
+			text:  "one two three four",
+			limit: 14,
+			want:  []string{"one two", "three four"},
+		},
+		{
+			name:  "boundaries in the first half are ignored",
+			text:  "a bcdefghijklmnopqrstuvwxyz",
+			limit: 14,
+			want:  []string{"a bcdefghi", "jklmnopqrs", "tuvwxyz"},
+		},
+		{
+			name:  "long word",
+			text:  "abcdefghijklmnopqrstuvwxyz",
+			limit: 14,
+			want:  []string{"abcdefghij", "klmnopqrst", "uvwxyz"},
+		},
+		{
+			name:  "length in UTF-16 code units",
+			text:  "😀😀😀😀😀😀 x",
+			limit: 12,
+			want:  []string{"😀😀😀😀", "😀😀 x"},
+		},
+		{
+			name:  "code block crossing the boundary",
+			text:  "text\n```go\nline one\nline two\n```\nafter",
+			limit: 24,
+			want:  []string{"text\n```go\nline one\n```", "```go\nline two\n```\nafter"},
+		},
+		{
+			name:  "closed code block is not reopened",
+			text:  "```\ncode\n```\nsome text after it",
+			limit: 24,
+			want:  []string{"```\ncode\n```", "some text after it"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := SplitMessage(tt.text, tt.limit)
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") || len(got) != len(tt.want) {
+				t.Errorf("SplitMessage(%q, %d) = %q, want %q", tt.text, tt.limit, got, tt.want)
+			}
+			for _, part := range got {
+				if textLength(part) > tt.limit {
+					t.Errorf("part %q is longer than %d", part, tt.limit)
+				}
+			}
+		})
+	}
+}
+
+func TestSplitMessageMarkdown(t *testing.T) {
+	// Every part of a long code block is formatted on its own.
+	text := "Example:\n```go\n" + strings.Repeat("fmt.Println(\"<a>\")\n", 20) + "```\nDone."
+	parts := SplitMessage(text, 100)
+	if len(parts) < 2 {
+		t.Fatalf("parts = %q, want several", parts)
+	}
+	for i, part := range parts {
+		html := MarkdownToHTML(part)
+		if i > 0 && !strings.HasPrefix(html, `<pre><code class="language-go">`) {
+			t.Errorf("part %d = %q, want it to start with the code block", i, html)
+		}
+		if strings.Count(html, "<pre>") != strings.Count(html, "</pre>") || strings.Contains(html, "```") {
+			t.Errorf("part %d = %q, want a closed code block", i, html)
+		}
+	}
+}
@@ -7,12 +7,17 @@ import (
 	"github.com/mkuznets/telebot/v3"
 )
 
+// Sequential processes the updates with the same key one at a time.
+// Updates with an empty key bypass the lock.
 func Sequential(keyFn func(c telebot.Context) string) telebot.MiddlewareFunc {
 	var locks sync.Map
 
 	return func(next telebot.HandlerFunc) telebot.HandlerFunc {
 		return func(c telebot.Context) error {
 			key := keyFn(c)
+			if key == "" {
+				return next(c)
+			}
 			v, _ := locks.LoadOrStore(key, new(sync.Mutex))
 			lock, ok := v.(*sync.Mutex)
 			if !ok {
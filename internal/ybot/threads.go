@@ -0,0 +1,70 @@
+package ybot
+
+import (
+	"github.com/mkuznets/telebot/v3"
+)
+
+// ThreadID returns the forum topic of the update, or zero if there is none.
+func ThreadID(c telebot.Context) int {
+	if msg := c.Message(); msg != nil && msg.TopicMessage {
+		return msg.ThreadID
+	}
+	return 0
+}
+
+// InThread sends the messages and the chat actions of the context into the forum topic
+// of the update, if any.
+func InThread(next telebot.HandlerFunc) telebot.HandlerFunc {
+	return func(c telebot.Context) error {
+		if threadId := ThreadID(c); threadId != 0 {
+			c = &threadContext{Context: c, threadId: threadId}
+		}
+		return next(c)
+	}
+}
+
+type threadContext struct {
+	telebot.Context
+	threadId int
+}
+
+func (c *threadContext) Send(what interface{}, opts ...interface{}) error {
+	return c.Context.Send(what, WithThread(c.threadId, opts)...)
+}
+
+func (c *threadContext) SendAlbum(a telebot.Album, opts ...interface{}) error {
+	return c.Context.SendAlbum(a, WithThread(c.threadId, opts)...)
+}
+
+func (c *threadContext) Reply(what interface{}, opts ...interface{}) error {
+	return c.Context.Reply(what, WithThread(c.threadId, opts)...)
+}
+
+func (c *threadContext) Notify(action telebot.ChatAction) error {
+	return c.Bot().Notify(c.Recipient(), action, c.threadId)
+}
+
+// Send sends the message to the chat of the context, into its forum topic if any.
+func Send(c telebot.Context, what interface{}, opts ...interface{}) (*telebot.Message, error) {
+	return c.Bot().Send(c.Recipient(), what, WithThread(ThreadID(c), opts)...)
+}
+
+// WithThread adds the forum topic to the telebot send options. Since telebot replaces
+// the options collected so far with *telebot.SendOptions, the topic is set in each of them.
+func WithThread(threadId int, opts []interface{}) []interface{} {
+	if threadId == 0 {
+		return opts
+	}
+
+	res := make([]interface{}, 0, len(opts)+1)
+	res = append(res, &telebot.SendOptions{ThreadID: threadId})
+	for _, o := range opts {
+		if so, ok := o.(*telebot.SendOptions); ok && so != nil {
+			cp := *so
+			cp.ThreadID = threadId
+			o = &cp
+		}
+		res = append(res, o)
+	}
+	return res
+}
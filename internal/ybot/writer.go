@@ -14,24 +14,63 @@ import (
 
 const (
 	writerUpdateInterval = 3000 * time.Millisecond
+	// writerCloseTimeout limits the time spent on delivering the final text.
+	writerCloseTimeout = time.Minute
 )
 
+// Writer streams the text into the messages of the reply, sending new ones
+// when the text does not fit into the existing messages.
 type Writer struct {
-	buf    *strings.Builder
-	bot    *telebot.Bot
-	msg    telebot.Editable
+	buf     *strings.Builder
+	bot     *telebot.Bot
+	limiter *Limiter
+	reply   *Reply
+	chatId  int64
+	// opts are the options of the new messages, e.g. their forum topic.
+	opts   *telebot.SendOptions
+	markup *telebot.ReplyMarkup
 	mu     *sync.RWMutex
 	cancel context.CancelFunc
+
+	// parts are the messages with the parts of the text, starting with the ones of the reply.
+	parts []*writerPart
+}
+
+type writerPart struct {
+	msg    telebot.Editable
+	text   string
+	markup bool
 }
 
-func NewWriter(ctx context.Context, bot *telebot.Bot, msg telebot.Editable) *Writer {
+// NewWriter creates a writer that periodically updates the messages of the reply with the written text.
+// The updates of all writers sharing the limiter are kept within the Telegram rate limits.
+// The markup is shown under the last part while the text is being written and removed on Close.
+// The parts that do not fit are sent with the options, e.g. into a forum topic or in reply to a message.
+// The reply is updated with the messages of the text on Close.
+func NewWriter(ctx context.Context, bot *telebot.Bot, limiter *Limiter, reply *Reply, opts *telebot.SendOptions, markup *telebot.ReplyMarkup) *Writer {
+	_, chatId := reply.First().MessageSig()
+
+	parts := make([]*writerPart, len(reply.Parts))
+	for i, msg := range reply.Parts {
+		parts[i] = &writerPart{msg: msg}
+	}
+
+	if opts == nil {
+		opts = &telebot.SendOptions{}
+	}
+
 	cctx, cancel := context.WithCancel(ctx)
 	writer := &Writer{
-		bot:    bot,
-		buf:    &strings.Builder{},
-		msg:    msg,
-		mu:     &sync.RWMutex{},
-		cancel: cancel,
+		bot:     bot,
+		limiter: limiter,
+		buf:     &strings.Builder{},
+		reply:   reply,
+		chatId:  chatId,
+		opts:    opts,
+		markup:  markup,
+		mu:      &sync.RWMutex{},
+		cancel:  cancel,
+		parts:   parts,
 	}
 	go writer.doUpdate(cctx)
 
@@ -46,16 +85,26 @@ func (w *Writer) doUpdate(ctx context.Context) {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
-	lastMessage := ""
-
 	err := ytime.NewTicker(writerUpdateInterval).
 		Start(ctx, func() error {
 			message := w.buf.String()
-			if strings.TrimSpace(lastMessage) != strings.TrimSpace(message) {
-				lastMessage = message
-				_, err := w.bot.Edit(w.msg, message)
-				if err != nil {
-					slog.Error("writer edit", ylog.Err(err))
+			if strings.TrimSpace(message) == "" {
+				return nil
+			}
+
+			parts := SplitMessage(message, MessageLimit)
+			for i, text := range parts {
+				// Only the last part has the markup while the text is being written.
+				var markup *telebot.ReplyMarkup
+				if i == len(parts)-1 {
+					markup = w.markup
+				}
+				// The failed part is retried with the latest text on the next tick.
+				if err := w.updatePart(ctx, i, text, markup); err != nil {
+					if ctx.Err() == nil {
+						slog.Error("writer edit", ylog.Err(err))
+					}
+					break
 				}
 			}
 
@@ -66,19 +115,118 @@ func (w *Writer) doUpdate(ctx context.Context) {
 	}
 }
 
+// updatePart shows the formatted part of the text in its message, sending a new one
+// if the part does not exist yet. Nothing is done if the part has not changed.
+func (w *Writer) updatePart(ctx context.Context, i int, text string, markup *telebot.ReplyMarkup) error {
+	if strings.TrimSpace(text) == "" {
+		return nil
+	}
+	if i < len(w.parts) && strings.TrimSpace(w.parts[i].text) == strings.TrimSpace(text) && w.parts[i].markup == (markup != nil) {
+		return nil
+	}
+
+	err := w.sendPart(ctx, i, MarkdownToHTML(text), telebot.ModeHTML, markup)
+	if err != nil && !IsFloodError(err) && ctx.Err() == nil {
+		slog.Error("writer: html edit", ylog.Err(err))
+		err = w.sendPart(ctx, i, text, telebot.ModeDefault, markup)
+	}
+	if err != nil {
+		return err
+	}
+
+	w.parts[i].text = text
+	w.parts[i].markup = markup != nil
+	return nil
+}
+
+func (w *Writer) sendPart(ctx context.Context, i int, text string, mode telebot.ParseMode, markup *telebot.ReplyMarkup) error {
+	if err := w.limiter.Wait(ctx, w.chatId); err != nil {
+		return err
+	}
+
+	if i < len(w.parts) {
+		_, err := w.bot.Edit(w.parts[i].msg, text, &telebot.SendOptions{ParseMode: mode, ReplyMarkup: markup})
+		w.limiter.Backoff(w.chatId, err)
+		return err
+	}
+
+	opts := *w.opts
+	opts.ParseMode = mode
+	opts.ReplyMarkup = markup
+	msg, err := w.bot.Send(telebot.ChatID(w.chatId), text, &opts)
+	if err != nil {
+		w.limiter.Backoff(w.chatId, err)
+		return err
+	}
+	w.parts = append(w.parts, &writerPart{msg: msg})
+	return nil
+}
+
+// Close shows the final text without the markup. The messages of the reply left from
+// a longer text are deleted. If nothing has been written, the messages are kept as is
+// and only the markup is removed. Unlike the intermediate updates, the final ones are
+// retried after the delay requested by Telegram.
 func (w *Writer) Close() {
 	w.cancel()
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
-	message := w.buf.String()
-	_, mErr := w.bot.Edit(w.msg, message, &telebot.SendOptions{ParseMode: telebot.ModeMarkdown})
-	if mErr != nil {
-		slog.Error("closing writer: markdown edit", ylog.Err(mErr))
+	ctx, cancel := context.WithTimeout(context.Background(), writerCloseTimeout)
+	defer cancel()
+
+	defer func() {
+		w.reply.Parts = make([]telebot.Editable, len(w.parts))
+		for i, p := range w.parts {
+			w.reply.Parts[i] = p.msg
+		}
+	}()
+
+	if strings.TrimSpace(w.buf.String()) == "" {
+		w.removeMarkup(ctx, w.parts[0].msg)
+		return
+	}
+
+	parts := SplitMessage(w.buf.String(), MessageLimit)
+	for i, text := range parts {
+		// The later parts cannot be sent in order if this one is missing.
+		err := retryFlood(ctx, func() error { return w.updatePart(ctx, i, text, nil) })
+		if err != nil {
+			slog.Error("closing writer: edit", ylog.Err(err))
+			return
+		}
+	}
+
+	for len(w.parts) > len(parts) {
+		stale := w.parts[len(w.parts)-1]
+		err := w.limiter.Do(ctx, w.chatId, func() error {
+			return w.bot.Delete(stale.msg)
+		})
+		if err != nil {
+			// The message may be too old to be deleted, so it is emptied instead.
+			slog.Error("closing writer: delete", ylog.Err(err))
+			_ = retryFlood(ctx, func() error { return w.sendPart(ctx, len(w.parts)-1, "…", telebot.ModeDefault, nil) })
+		}
+		w.parts = w.parts[:len(w.parts)-1]
+	}
+}
+
+func (w *Writer) removeMarkup(ctx context.Context, msg telebot.Editable) {
+	err := w.limiter.Do(ctx, w.chatId, func() error {
+		_, err := w.bot.EditReplyMarkup(msg, nil)
+		return err
+	})
+	if err != nil {
+		slog.Error("closing writer: edit markup", ylog.Err(err))
+	}
+}
 
-		_, pErr := w.bot.Edit(w.msg, message)
-		if pErr != nil {
-			slog.Error("closing writer: plaintext edit", ylog.Err(pErr))
+// retryFlood calls the function until it succeeds or fails with an error other than FloodError.
+// The delay requested by Telegram is expected to be observed by the limiter.
+func retryFlood(ctx context.Context, f func() error) error {
+	for {
+		err := f()
+		if err == nil || !IsFloodError(err) || ctx.Err() != nil {
+			return err
 		}
 	}
 }
@@ -53,6 +53,23 @@ table "users" {
     null = true
     type = text
   }
+  column "keep_history" {
+    null    = false
+    type    = integer
+    default = 0
+  }
+  column "dialog_resumed_at" {
+    null = true
+    type = integer
+  }
+  column "dialog_retention" {
+    null = true
+    type = integer
+  }
+  column "dialog_expired_at" {
+    null = true
+    type = integer
+  }
 
   primary_key {
     columns = [column.chat_id]
@@ -101,6 +118,23 @@ table "messages" {
     null = false
     type = integer
   }
+  column "dialog_id" {
+    null = true
+    type = text
+  }
+  column "tg_message_id" {
+    null = true
+    type = integer
+  }
+  column "thread_id" {
+    null = true
+    type = integer
+  }
+  column "summarized" {
+    null    = false
+    type    = integer
+    default = 0
+  }
 
   primary_key {
     columns = [column.id]
@@ -114,6 +148,65 @@ table "messages" {
   index "messages_chat_id_idx" {
     columns = [column.chat_id]
   }
+  index "messages_chat_id_dialog_id_idx" {
+    columns = [column.chat_id, column.dialog_id]
+  }
+
+  check {
+    expr = "(created_at > 0)"
+  }
+
+  strict = true
+}
+
+table "topics" {
+  schema = schema.main
+  column "chat_id" {
+    null = false
+    type = integer
+  }
+  column "thread_id" {
+    null = false
+    type = integer
+  }
+  column "dialog_id" {
+    null = true
+    type = text
+  }
+  column "model" {
+    null = true
+    type = text
+  }
+  column "system_prompt" {
+    null = true
+    type = text
+  }
+  column "input_state" {
+    null = true
+    type = text
+  }
+  column "dialog_resumed_at" {
+    null = true
+    type = integer
+  }
+  column "created_at" {
+    null = false
+    type = integer
+  }
+  column "dialog_expired_at" {
+    null = true
+    type = integer
+  }
+
+  primary_key {
+    columns = [column.chat_id, column.thread_id]
+  }
+  foreign_key "chat_id" {
+    columns     = [column.chat_id]
+    ref_columns = [table.users.column.chat_id]
+    on_update   = NO_ACTION
+    on_delete   = CASCADE
+  }
 
   check {
     expr = "(created_at > 0)"
@@ -152,10 +245,19 @@ table "usage" {
     null = false
     type = integer
   }
+  column "audio_seconds" {
+    null    = false
+    type    = integer
+    default = 0
+  }
   column "created_at" {
     null = false
     type = integer
   }
+  column "sender_id" {
+    null = true
+    type = integer
+  }
 
   primary_key {
     columns = [column.id]
@@ -169,6 +271,12 @@ table "usage" {
   index "usage_chat_id_created_at_idx" {
     columns = [column.chat_id, column.created_at]
   }
+  index "usage_created_at_idx" {
+    columns = [column.created_at]
+  }
+  index "usage_sender_id_created_at_idx" {
+    columns = [column.sender_id, column.created_at]
+  }
 
   check {
     expr = "(created_at > 0)"
@@ -177,4 +285,35 @@ table "usage" {
   strict = true
 }
 
+table "message_parts" {
+  schema = schema.main
+  column "message_id" {
+    null = false
+    type = integer
+  }
+  column "part" {
+    null = false
+    type = integer
+  }
+  column "tg_message_id" {
+    null = false
+    type = integer
+  }
+
+  primary_key {
+    columns = [column.message_id, column.part]
+  }
+  foreign_key "message_id" {
+    columns     = [column.message_id]
+    ref_columns = [table.messages.column.id]
+    on_update   = NO_ACTION
+    on_delete   = CASCADE
+  }
+  index "message_parts_tg_message_id_idx" {
+    columns = [column.tg_message_id]
+  }
+
+  strict = true
+}
+
 schema "main" {}
@@ -0,0 +1,2 @@
+-- Create index "usage_created_at_idx" to table: "usage"
+CREATE INDEX `usage_created_at_idx` ON `usage` (`created_at`);
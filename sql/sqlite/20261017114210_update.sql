@@ -0,0 +1,8 @@
+-- Add column "keep_history" to table: "users"
+ALTER TABLE `users` ADD COLUMN `keep_history` integer NOT NULL DEFAULT 0;
+-- Add column "dialog_id" to table: "messages"
+ALTER TABLE `messages` ADD COLUMN `dialog_id` text NULL;
+-- Assign the existing messages to the current dialogs of the users
+UPDATE `messages` SET `dialog_id` = (SELECT `dialog_id` FROM `users` WHERE `users`.`chat_id` = `messages`.`chat_id`);
+-- Create index "messages_chat_id_dialog_id_idx" to table: "messages"
+CREATE INDEX `messages_chat_id_dialog_id_idx` ON `messages` (`chat_id`, `dialog_id`);
@@ -0,0 +1,2 @@
+-- Add column "dialog_resumed_at" to table: "users"
+ALTER TABLE `users` ADD COLUMN `dialog_resumed_at` integer NULL;
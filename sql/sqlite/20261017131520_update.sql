@@ -0,0 +1,2 @@
+-- Add column "dialog_retention" to table: "users"
+ALTER TABLE `users` ADD COLUMN `dialog_retention` integer NULL;
@@ -0,0 +1,2 @@
+-- Add column "tg_message_id" to table: "messages"
+ALTER TABLE `messages` ADD COLUMN `tg_message_id` integer NULL;
@@ -0,0 +1,4 @@
+-- Add column "thread_id" to table: "messages"
+ALTER TABLE `messages` ADD COLUMN `thread_id` integer NULL;
+-- Create "topics" table
+CREATE TABLE `topics` (`chat_id` integer NOT NULL, `thread_id` integer NOT NULL, `dialog_id` text NULL, `model` text NULL, `system_prompt` text NULL, `input_state` text NULL, `dialog_resumed_at` integer NULL, `created_at` integer NOT NULL, PRIMARY KEY (`chat_id`, `thread_id`), CONSTRAINT `chat_id` FOREIGN KEY (`chat_id`) REFERENCES `users` (`chat_id`) ON UPDATE NO ACTION ON DELETE CASCADE, CHECK (created_at > 0)) strict;
@@ -0,0 +1,2 @@
+-- Add column "audio_seconds" to table: "usage"
+ALTER TABLE `usage` ADD COLUMN `audio_seconds` integer NOT NULL DEFAULT 0;
@@ -0,0 +1,2 @@
+-- Add column "summarized" to table: "messages"
+ALTER TABLE `messages` ADD COLUMN `summarized` integer NOT NULL DEFAULT 0;
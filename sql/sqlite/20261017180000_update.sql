@@ -0,0 +1,4 @@
+-- Create "message_parts" table
+CREATE TABLE `message_parts` (`message_id` integer NOT NULL, `part` integer NOT NULL, `tg_message_id` integer NOT NULL, PRIMARY KEY (`message_id`, `part`), CONSTRAINT `message_id` FOREIGN KEY (`message_id`) REFERENCES `messages` (`id`) ON UPDATE NO ACTION ON DELETE CASCADE) strict;
+-- Create index "message_parts_tg_message_id_idx" to table: "message_parts"
+CREATE INDEX `message_parts_tg_message_id_idx` ON `message_parts` (`tg_message_id`);
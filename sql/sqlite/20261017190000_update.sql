@@ -0,0 +1,6 @@
+-- Add column "sender_id" to table: "usage"
+ALTER TABLE `usage` ADD COLUMN `sender_id` integer NULL;
+-- Create index "usage_sender_id_created_at_idx" to table: "usage"
+CREATE INDEX `usage_sender_id_created_at_idx` ON `usage` (`sender_id`, `created_at`);
+-- The usage of the private chats belongs to their users
+UPDATE `usage` SET `sender_id` = `chat_id` WHERE `chat_id` > 0;
@@ -0,0 +1,4 @@
+-- Add column "dialog_expired_at" to table: "users"
+ALTER TABLE `users` ADD COLUMN `dialog_expired_at` integer NULL;
+-- Add column "dialog_expired_at" to table: "topics"
+ALTER TABLE `topics` ADD COLUMN `dialog_expired_at` integer NULL;